@@ -0,0 +1,187 @@
+package ahrs
+
+import (
+	"errors"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/num/quat"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// VectorObservation is a direction measured in the sensor frame paired with
+// the same direction expressed in the earth frame.
+type VectorObservation struct {
+	Sensor    r3.Vec
+	Reference r3.Vec
+	// Weight is the relative confidence in the observation, usually the
+	// inverse of the measurement variance.
+	Weight float64
+}
+
+// AverageAcceleration reads the IMU accelerometer n times and returns the mean
+// reading in micro gravities. The IMU should be static while reading.
+func AverageAcceleration(imu IMU, n int) r3.Vec {
+	var sum r3.Vec
+	for i := 0; i < n; i++ {
+		ax, ay, az := imu.Acceleration()
+		sum = r3.Add(sum, scaledVecFromInt(1, ax, ay, az))
+	}
+	return r3.Scale(1/float64(n), sum)
+}
+
+// AverageNorth returns the average of n magnetometer readings in nanoteslas.
+func AverageNorth(m Magnetometer, n int) r3.Vec {
+	var sum r3.Vec
+	for i := 0; i < n; i++ {
+		mx, my, mz := m.North()
+		sum = r3.Add(sum, scaledVecFromInt(1, mx, my, mz))
+	}
+	return r3.Scale(1/float64(n), sum)
+}
+
+// TRIAD returns the attitude of a static sensor from a single accelerometer
+// and magnetometer reading, i.e. averages obtained with AverageAcceleration and
+// AverageNorth. Units are irrelevant, only directions are used. The accelerometer
+// is trusted exactly and the magnetometer only determines heading.
+// The attitude is relative to the earth frame of convention c so it may seed
+// an estimator created with the same convention.
+func TRIAD(c Convention, accel, magnet r3.Vec) (quat.Number, error) {
+	s1, s2, err := triad(accel, magnet)
+	if err != nil {
+		return quat.Number{}, err
+	}
+	// Earth frame triad: up, west, up x west = south.
+	r1, r2 := c.fromNWU(r3.Vec{Z: 1}), c.fromNWU(r3.Vec{Y: 1})
+	s3, r3v := r3.Cross(s1, s2), r3.Cross(r1, r2)
+	// sensor to earth rotation is R = [r1 r2 r3] * [s1 s2 s3]ᵀ.
+	var R Matrix3
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			R[i][j] = vecAt(r1, i)*vecAt(s1, j) + vecAt(r2, i)*vecAt(s2, j) + vecAt(r3v, i)*vecAt(s3, j)
+		}
+	}
+	return quatFromMatrix3(R), nil
+}
+
+// CoarseAlignment returns the attitude of a static sensor from averaged accelerometer
+// and magnetometer readings by the Davenport q-method. Unlike TRIAD both vectors contribute
+// to roll and pitch according to their weights. The magnetic reference direction is
+// derived from the measured inclination so no location dependent model is needed.
+// The attitude is relative to the earth frame of convention c.
+func CoarseAlignment(c Convention, accel, magnet r3.Vec, accelWeight, magWeight float64) (quat.Number, error) {
+	up, _, err := triad(accel, magnet)
+	if err != nil {
+		return quat.Number{}, err
+	}
+	m := r3.Unit(magnet)
+	// Inclination is the angle between the magnetic field and the horizontal plane.
+	sinDip := r3.Dot(up, m)
+	ref := r3.Vec{X: math.Sqrt(math.Max(1-sinDip*sinDip, 0)), Z: sinDip}
+	return Davenport([]VectorObservation{
+		{Sensor: up, Reference: c.fromNWU(r3.Vec{Z: 1}), Weight: accelWeight},
+		{Sensor: m, Reference: c.fromNWU(ref), Weight: magWeight},
+	})
+}
+
+// Davenport returns the attitude which best rotates the sensor frame observations
+// onto their earth frame references in the weighted least squares sense (Wahba's problem)
+// by the Davenport q-method. At least two non parallel observations are required.
+// The attitude is relative to the earth frame the references are expressed in,
+// which should match the convention of the estimator it seeds.
+func Davenport(observations []VectorObservation) (quat.Number, error) {
+	// Attitude profile matrix B = Σ w * sensor * referenceᵀ.
+	var B Matrix3
+	var z r3.Vec
+	for _, o := range observations {
+		if o.Weight <= 0 {
+			continue
+		}
+		s, r := r3.Unit(o.Sensor), r3.Unit(o.Reference)
+		if math.IsNaN(s.X) || math.IsNaN(r.X) {
+			return quat.Number{}, errors.New("zero vector observation")
+		}
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				B[i][j] += o.Weight * vecAt(s, i) * vecAt(r, j)
+			}
+		}
+		z = r3.Add(z, r3.Scale(o.Weight, r3.Cross(s, r)))
+	}
+	trace := B[0][0] + B[1][1] + B[2][2]
+	K := mat.NewSymDense(4, nil)
+	for i := 0; i < 3; i++ {
+		for j := i; j < 3; j++ {
+			v := B[i][j] + B[j][i]
+			if i == j {
+				v -= trace
+			}
+			K.SetSym(i, j, v)
+		}
+		K.SetSym(i, 3, vecAt(z, i))
+	}
+	K.SetSym(3, 3, trace)
+	var eig mat.EigenSym
+	if !eig.Factorize(K, true) {
+		return quat.Number{}, errors.New("davenport eigendecomposition failed")
+	}
+	values := eig.Values(nil)
+	// Eigenvalues are in ascending order. Equal largest eigenvalues mean the attitude is unobservable.
+	if values[3]-values[2] < 1e-12*math.Max(math.Abs(values[3]), 1) {
+		return quat.Number{}, errors.New("observations do not determine attitude")
+	}
+	var vecs mat.Dense
+	eig.VectorsTo(&vecs)
+	q := quat.Number{Real: vecs.At(3, 3), Imag: vecs.At(0, 3), Jmag: vecs.At(1, 3), Kmag: vecs.At(2, 3)}
+	if q.Real < 0 {
+		q = quat.Scale(-1, q)
+	}
+	return NormalizeQuaternion(q), nil
+}
+
+// triad returns the unit up and west directions in the sensor frame.
+func triad(accel, magnet r3.Vec) (up, west r3.Vec, err error) {
+	if accel == (r3.Vec{}) || magnet == (r3.Vec{}) {
+		return up, west, errors.New("zero accelerometer or magnetometer vector")
+	}
+	up = r3.Unit(accel)
+	west = r3.Cross(up, r3.Unit(magnet))
+	if r3.Norm(west) < 1e-6 {
+		return up, west, errors.New("accelerometer and magnetometer vectors are parallel")
+	}
+	return up, r3.Unit(west), nil
+}
+
+// quatFromMatrix3 returns the unit quaternion of rotation matrix m.
+func quatFromMatrix3(m Matrix3) quat.Number {
+	var q quat.Number
+	trace := m[0][0] + m[1][1] + m[2][2]
+	switch {
+	case trace > 0:
+		s := 0.5 / math.Sqrt(trace+1)
+		q = quat.Number{Real: 0.25 / s, Imag: (m[2][1] - m[1][2]) * s, Jmag: (m[0][2] - m[2][0]) * s, Kmag: (m[1][0] - m[0][1]) * s}
+	case m[0][0] > m[1][1] && m[0][0] > m[2][2]:
+		s := 2 * math.Sqrt(1+m[0][0]-m[1][1]-m[2][2])
+		q = quat.Number{Real: (m[2][1] - m[1][2]) / s, Imag: 0.25 * s, Jmag: (m[0][1] + m[1][0]) / s, Kmag: (m[0][2] + m[2][0]) / s}
+	case m[1][1] > m[2][2]:
+		s := 2 * math.Sqrt(1+m[1][1]-m[0][0]-m[2][2])
+		q = quat.Number{Real: (m[0][2] - m[2][0]) / s, Imag: (m[0][1] + m[1][0]) / s, Jmag: 0.25 * s, Kmag: (m[1][2] + m[2][1]) / s}
+	default:
+		s := 2 * math.Sqrt(1+m[2][2]-m[0][0]-m[1][1])
+		q = quat.Number{Real: (m[1][0] - m[0][1]) / s, Imag: (m[0][2] + m[2][0]) / s, Jmag: (m[1][2] + m[2][1]) / s, Kmag: 0.25 * s}
+	}
+	if q.Real < 0 {
+		q = quat.Scale(-1, q)
+	}
+	return NormalizeQuaternion(q)
+}
+
+func vecAt(v r3.Vec, i int) float64 {
+	switch i {
+	case 0:
+		return v.X
+	case 1:
+		return v.Y
+	}
+	return v.Z
+}
@@ -0,0 +1,84 @@
+package ahrs
+
+import (
+	"testing"
+
+	"gonum.org/v1/gonum/num/quat"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestCoarseAlignment(t *testing.T) {
+	const tol = 1e-6
+	// Field in North-West-Up with 60° inclination.
+	earthMag := r3.Vec{X: 25000, Z: -43301}
+	attitudes := []quat.Number{
+		quatIdentity,
+		NormalizeQuaternion(quat.Number{Real: 0.9, Imag: 0.1, Jmag: -0.3, Kmag: 0.2}),
+		NormalizeQuaternion(quat.Number{Real: 0.1, Imag: -0.5, Jmag: 0.4, Kmag: 0.7}),
+	}
+	for c := ConventionNWU; c < conventionLen; c++ {
+		for _, want := range attitudes {
+			accel := rotateVecInv(want, c.fromNWU(r3.Vec{Z: 1e6}))
+			magnet := rotateVecInv(want, c.fromNWU(earthMag))
+			got, err := TRIAD(c, accel, magnet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if angle := quatAngle(want, got); angle > tol {
+				t.Errorf("%v TRIAD: expected %v, got %v (%g rad)", c, want, got, angle)
+			}
+			got, err = CoarseAlignment(c, accel, magnet, 1, 0.5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if angle := quatAngle(want, got); angle > tol {
+				t.Errorf("%v CoarseAlignment: expected %v, got %v (%g rad)", c, want, got, angle)
+			}
+		}
+	}
+	if _, err := TRIAD(ConventionNWU, r3.Vec{Z: 1}, r3.Vec{Z: -2}); err == nil {
+		t.Error("expected error for parallel vectors")
+	}
+}
+
+func TestSetAttitude(t *testing.T) {
+	want := NormalizeQuaternion(quat.Number{Real: 0.9, Imag: 0.1, Jmag: -0.3, Kmag: 0.2})
+	sample := Sample{
+		Accel:  rotateVecInv(want, r3.Vec{Z: StandardGravity}),
+		Mag:    rotateVecInv(want, r3.Vec{X: 20e-6, Z: -45e-6}),
+		Period: 0.01,
+	}
+	for name, e := range testEstimators() {
+		e.SetAttitude(want)
+		e.UpdateSample(sample)
+		if angle := quatAngle(want, e.Attitude()); angle > 1e-3 {
+			t.Errorf("%s: seeded attitude moved %g rad", name, angle)
+		}
+	}
+}
+
+func TestSeedConvention(t *testing.T) {
+	const dt = 0.01
+	want := NormalizeQuaternion(quat.Number{Real: 0.9, Imag: 0.1, Jmag: -0.3, Kmag: 0.2})
+	// Level field pointing north and down in North-East-Down.
+	sample := Sample{
+		Accel:  rotateVecInv(want, r3.Vec{Z: -StandardGravity}),
+		Mag:    rotateVecInv(want, r3.Vec{X: 20e-6, Z: 45e-6}),
+		Period: dt,
+	}
+	seed, err := TRIAD(ConventionNED, sample.Accel, sample.Mag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if angle := quatAngle(want, seed); angle > 1e-6 {
+		t.Fatalf("expected NED seed %v, got %v (%g rad)", want, seed, angle)
+	}
+	f := NewXio(0.5, WithConvention(ConventionNED))
+	f.SetAttitude(seed)
+	for i := 0; i < 100; i++ {
+		f.UpdateSample(sample)
+	}
+	if angle := quatAngle(want, f.Attitude()); angle > 1e-3 {
+		t.Errorf("seeded NED attitude moved %g rad", angle)
+	}
+}
@@ -0,0 +1,126 @@
+package ahrs
+
+import (
+	"math"
+)
+
+// Ratio between bias instability and the minimum of the Allan deviation, sqrt(2*ln(2)/π).
+const allanBiasInstabilityFactor = 0.6642824702679830
+
+// Maximum difference between the local log-log slope of an Allan deviation
+// curve and the slope of a noise term for the term to be considered present.
+const allanSlopeTolerance = 0.25
+
+// AllanPoint is a point of an Allan deviation curve.
+type AllanPoint struct {
+	// Tau is the cluster time in seconds.
+	Tau float64
+	// Deviation is the Allan deviation in the units of the samples.
+	Deviation float64
+}
+
+// AllanDeviation returns the overlapping Allan deviation of rate samples
+// (i.e. gyroscope readings in rad/s or accelerometer readings in m/s²)
+// recorded at a constant samplePeriod in seconds while the sensor is static.
+// Cluster times are log-spaced with pointsPerDecade points per decade,
+// from samplePeriod up to half the recording length.
+func AllanDeviation(samples []float64, samplePeriod float64, pointsPerDecade int) []AllanPoint {
+	n := len(samples)
+	if n < 3 || !(samplePeriod > 0) || pointsPerDecade <= 0 {
+		return nil
+	}
+	// Integrate rate to obtain angle (or velocity).
+	theta := make([]float64, n+1)
+	for i, s := range samples {
+		theta[i+1] = theta[i] + s*samplePeriod
+	}
+	maxM := (n - 1) / 2
+	var curve []AllanPoint
+	lastM := 0
+	for i := 0; ; i++ {
+		m := int(math.Round(math.Pow(10, float64(i)/float64(pointsPerDecade))))
+		if m > maxM {
+			break
+		}
+		if m == lastM {
+			continue
+		}
+		lastM = m
+		tau := float64(m) * samplePeriod
+		var sum float64
+		for k := 0; k+2*m <= n; k++ {
+			d := theta[k+2*m] - 2*theta[k+m] + theta[k]
+			sum += d * d
+		}
+		variance := sum / (2 * tau * tau * float64(n+1-2*m))
+		curve = append(curve, AllanPoint{Tau: tau, Deviation: math.Sqrt(variance)})
+	}
+	return curve
+}
+
+// AllanNoise holds the noise coefficients extracted from an Allan deviation curve.
+// Coefficients of noise terms which can not be identified on the curve are zero.
+type AllanNoise struct {
+	// RandomWalk is the angle (or velocity) random walk coefficient N
+	// in units/√Hz, e.g. rad/s/√Hz. It is read on the slope -1/2 line at τ=1s.
+	RandomWalk float64
+	// BiasInstability is the bias instability coefficient B in the units
+	// of the samples. It is read at the minimum of the curve if the curve
+	// flattens anywhere, that is, some point has a slope near zero.
+	BiasInstability float64
+	// BiasInstabilityTau is the cluster time in seconds of the curve minimum.
+	BiasInstabilityTau float64
+	// RateRandomWalk is the rate random walk coefficient K in units·√Hz,
+	// e.g. rad/s²/√Hz. It is read on the slope +1/2 line at τ=3s.
+	RateRandomWalk float64
+}
+
+// AllanNoiseCoefficients extracts the standard noise coefficients from
+// an Allan deviation curve as returned by AllanDeviation. Random walk terms are
+// read where the local log-log slope of the curve is closest to that of the term
+// and bias instability at the curve minimum.
+func AllanNoiseCoefficients(curve []AllanPoint) AllanNoise {
+	var noise AllanNoise
+	if len(curve) < 3 {
+		return noise
+	}
+	slopes := make([]float64, len(curve))
+	for i := range curve {
+		lo, hi := i-1, i+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi >= len(curve) {
+			hi = len(curve) - 1
+		}
+		slopes[i] = math.Log(curve[hi].Deviation/curve[lo].Deviation) / math.Log(curve[hi].Tau/curve[lo].Tau)
+	}
+	// closest returns the index of the point with slope closest to target
+	// or -1 if no point is within tolerance.
+	closest := func(target float64) int {
+		best, bestDiff := -1, allanSlopeTolerance
+		for i, s := range slopes {
+			if d := math.Abs(s - target); d <= bestDiff {
+				best, bestDiff = i, d
+			}
+		}
+		return best
+	}
+	if i := closest(-0.5); i >= 0 {
+		noise.RandomWalk = curve[i].Deviation * math.Sqrt(curve[i].Tau)
+	}
+	if i := closest(0.5); i >= 0 {
+		noise.RateRandomWalk = curve[i].Deviation * math.Sqrt(3/curve[i].Tau)
+	}
+	if closest(0) >= 0 {
+		min := 0
+		for i := range curve {
+			if curve[i].Deviation < curve[min].Deviation {
+				min = i
+			}
+		}
+		noise.BiasInstability = curve[min].Deviation / allanBiasInstabilityFactor
+		noise.BiasInstabilityTau = curve[min].Tau
+	}
+	return noise
+}
@@ -0,0 +1,56 @@
+package ahrs
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestAllanNoiseCoefficients(t *testing.T) {
+	const (
+		dt     = 0.01
+		n      = 1_000_000
+		arw    = 0.005  // rad/s/√Hz
+		rrw    = 0.0002 // rad/s²/√Hz
+		relTol = 0.2
+	)
+	rng := rand.New(rand.NewSource(1))
+	samples := make([]float64, n)
+	var bias float64
+	for i := range samples {
+		bias += rrw * math.Sqrt(dt) * rng.NormFloat64()
+		samples[i] = bias + arw/math.Sqrt(dt)*rng.NormFloat64()
+	}
+	curve := AllanDeviation(samples, dt, 10)
+	if len(curve) == 0 || curve[0].Tau != dt {
+		t.Fatalf("unexpected curve start %+v", curve[:1])
+	}
+	noise := AllanNoiseCoefficients(curve)
+	if math.Abs(noise.RandomWalk-arw) > relTol*arw {
+		t.Errorf("expected random walk %g, got %g", arw, noise.RandomWalk)
+	}
+	if math.Abs(noise.RateRandomWalk-rrw) > relTol*rrw {
+		t.Errorf("expected rate random walk %g, got %g", rrw, noise.RateRandomWalk)
+	}
+	min := curve[0]
+	for _, p := range curve {
+		if p.Deviation < min.Deviation {
+			min = p
+		}
+	}
+	if noise.BiasInstabilityTau != min.Tau || noise.BiasInstability != min.Deviation/allanBiasInstabilityFactor {
+		t.Errorf("expected bias instability at curve minimum %+v, got %+v", min, noise)
+	}
+}
+
+func TestAllanBiasInstabilityMinimum(t *testing.T) {
+	// Flattest point (τ=8s) is not the minimum (τ=16s).
+	curve := []AllanPoint{
+		{Tau: 1, Deviation: 1}, {Tau: 2, Deviation: 0.71}, {Tau: 4, Deviation: 0.52},
+		{Tau: 8, Deviation: 0.50}, {Tau: 16, Deviation: 0.49}, {Tau: 32, Deviation: 0.70},
+	}
+	noise := AllanNoiseCoefficients(curve)
+	if noise.BiasInstabilityTau != 16 || math.Abs(noise.BiasInstability-0.49/allanBiasInstabilityFactor) > 1e-12 {
+		t.Errorf("expected bias instability at curve minimum τ=16s, got %+v", noise)
+	}
+}
@@ -0,0 +1,179 @@
+package ahrs
+
+import (
+	"gonum.org/v1/gonum/num/quat"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// AxesAlignment is one of the 24 right angle rotations that map sensor axes to
+// body axes, named after the sensor axis that becomes each body axis. For example
+// AxesPXNZPY maps body X to +sensor X, body Y to -sensor Z and body Z to +sensor Y.
+// Equivalent to FusionAxesAlignment in xioTechnologies/Fusion.
+type AxesAlignment uint8
+
+const (
+	// Body X, Y, Z is sensor +X, +Y, +Z.
+	AxesPXPYPZ AxesAlignment = iota
+	// Body X, Y, Z is sensor +X, -Z, +Y.
+	AxesPXNZPY
+	// Body X, Y, Z is sensor +X, -Y, -Z.
+	AxesPXNYNZ
+	// Body X, Y, Z is sensor +X, +Z, -Y.
+	AxesPXPZNY
+	// Body X, Y, Z is sensor -X, +Y, -Z.
+	AxesNXPYNZ
+	// Body X, Y, Z is sensor -X, +Z, +Y.
+	AxesNXPZPY
+	// Body X, Y, Z is sensor -X, -Y, +Z.
+	AxesNXNYPZ
+	// Body X, Y, Z is sensor -X, -Z, -Y.
+	AxesNXNZNY
+	// Body X, Y, Z is sensor +Y, -X, +Z.
+	AxesPYNXPZ
+	// Body X, Y, Z is sensor +Y, -Z, -X.
+	AxesPYNZNX
+	// Body X, Y, Z is sensor +Y, +X, -Z.
+	AxesPYPXNZ
+	// Body X, Y, Z is sensor +Y, +Z, +X.
+	AxesPYPZPX
+	// Body X, Y, Z is sensor -Y, +X, +Z.
+	AxesNYPXPZ
+	// Body X, Y, Z is sensor -Y, -Z, +X.
+	AxesNYNZPX
+	// Body X, Y, Z is sensor -Y, -X, -Z.
+	AxesNYNXNZ
+	// Body X, Y, Z is sensor -Y, +Z, -X.
+	AxesNYPZNX
+	// Body X, Y, Z is sensor +Z, +Y, -X.
+	AxesPZPYNX
+	// Body X, Y, Z is sensor +Z, +X, +Y.
+	AxesPZPXPY
+	// Body X, Y, Z is sensor +Z, -Y, +X.
+	AxesPZNYPX
+	// Body X, Y, Z is sensor +Z, -X, -Y.
+	AxesPZNXNY
+	// Body X, Y, Z is sensor -Z, +Y, +X.
+	AxesNZPYPX
+	// Body X, Y, Z is sensor -Z, -X, +Y.
+	AxesNZNXPY
+	// Body X, Y, Z is sensor -Z, -Y, -X.
+	AxesNZNYNX
+	// Body X, Y, Z is sensor -Z, +X, -Y.
+	AxesNZPXNY
+	axesLen
+)
+
+var axesAlignmentMatrices = [axesLen]Matrix3{
+	AxesPXPYPZ: {{1, 0, 0}, {0, 1, 0}, {0, 0, 1}},
+	AxesPXNZPY: {{1, 0, 0}, {0, 0, -1}, {0, 1, 0}},
+	AxesPXNYNZ: {{1, 0, 0}, {0, -1, 0}, {0, 0, -1}},
+	AxesPXPZNY: {{1, 0, 0}, {0, 0, 1}, {0, -1, 0}},
+	AxesNXPYNZ: {{-1, 0, 0}, {0, 1, 0}, {0, 0, -1}},
+	AxesNXPZPY: {{-1, 0, 0}, {0, 0, 1}, {0, 1, 0}},
+	AxesNXNYPZ: {{-1, 0, 0}, {0, -1, 0}, {0, 0, 1}},
+	AxesNXNZNY: {{-1, 0, 0}, {0, 0, -1}, {0, -1, 0}},
+	AxesPYNXPZ: {{0, 1, 0}, {-1, 0, 0}, {0, 0, 1}},
+	AxesPYNZNX: {{0, 1, 0}, {0, 0, -1}, {-1, 0, 0}},
+	AxesPYPXNZ: {{0, 1, 0}, {1, 0, 0}, {0, 0, -1}},
+	AxesPYPZPX: {{0, 1, 0}, {0, 0, 1}, {1, 0, 0}},
+	AxesNYPXPZ: {{0, -1, 0}, {1, 0, 0}, {0, 0, 1}},
+	AxesNYNZPX: {{0, -1, 0}, {0, 0, -1}, {1, 0, 0}},
+	AxesNYNXNZ: {{0, -1, 0}, {-1, 0, 0}, {0, 0, -1}},
+	AxesNYPZNX: {{0, -1, 0}, {0, 0, 1}, {-1, 0, 0}},
+	AxesPZPYNX: {{0, 0, 1}, {0, 1, 0}, {-1, 0, 0}},
+	AxesPZPXPY: {{0, 0, 1}, {1, 0, 0}, {0, 1, 0}},
+	AxesPZNYPX: {{0, 0, 1}, {0, -1, 0}, {1, 0, 0}},
+	AxesPZNXNY: {{0, 0, 1}, {-1, 0, 0}, {0, -1, 0}},
+	AxesNZPYPX: {{0, 0, -1}, {0, 1, 0}, {1, 0, 0}},
+	AxesNZNXPY: {{0, 0, -1}, {-1, 0, 0}, {0, 1, 0}},
+	AxesNZNYNX: {{0, 0, -1}, {0, -1, 0}, {-1, 0, 0}},
+	AxesNZPXNY: {{0, 0, -1}, {1, 0, 0}, {0, -1, 0}},
+}
+
+// Matrix returns the sensor to body rotation matrix of the alignment.
+func (a AxesAlignment) Matrix() Matrix3 {
+	if a >= axesLen {
+		panic("invalid axes alignment")
+	}
+	return axesAlignmentMatrices[a]
+}
+
+// Apply returns the sensor reading v in body axes.
+func (a AxesAlignment) Apply(v r3.Vec) r3.Vec {
+	return a.Matrix().MulVec(v)
+}
+
+func (a AxesAlignment) String() string {
+	if a >= axesLen {
+		return "invalid axes alignment"
+	}
+	m := axesAlignmentMatrices[a]
+	b := make([]byte, 0, 6)
+	for _, row := range m {
+		for j, v := range row {
+			switch {
+			case v > 0:
+				b = append(b, '+', "XYZ"[j])
+			case v < 0:
+				b = append(b, '-', "XYZ"[j])
+			}
+		}
+	}
+	return string(b)
+}
+
+// MountFromQuat returns the sensor to body rotation matrix for a sensor
+// mounted with orientation q relative to the body.
+func MountFromQuat(q quat.Number) Matrix3 {
+	q = NormalizeQuaternion(q)
+	w, x, y, z := q.Real, q.Imag, q.Jmag, q.Kmag
+	return Matrix3{
+		{1 - 2*(y*y+z*z), 2 * (x*y - w*z), 2 * (x*z + w*y)},
+		{2 * (x*y + w*z), 1 - 2*(x*x+z*z), 2 * (y*z - w*x)},
+		{2 * (x*z - w*y), 2 * (y*z + w*x), 1 - 2*(x*x+y*y)},
+	}
+}
+
+// NewAlignedIMU returns an IMU whose accelerometer and gyroscope readings
+// are rotated from sensor axes to body axes by mount. mount is usually obtained
+// from AxesAlignment.Matrix or MountFromQuat.
+func NewAlignedIMU(imu IMU, mount Matrix3) IMU {
+	if imu == nil {
+		panic("nil IMU in NewAlignedIMU")
+	}
+	return alignedIMU{IMU: imu, mount: mount}
+}
+
+// NewAlignedIMUHeading returns an IMUHeading whose accelerometer, gyroscope
+// and magnetometer readings are rotated from sensor axes to body axes by mount.
+func NewAlignedIMUHeading(imu IMUHeading, mount Matrix3) IMUHeading {
+	if imu == nil {
+		panic("nil IMU in NewAlignedIMUHeading")
+	}
+	return alignedIMUHeading{alignedIMU: alignedIMU{IMU: imu, mount: mount}, mag: imu}
+}
+
+type alignedIMU struct {
+	IMU
+	mount Matrix3
+}
+
+func (a alignedIMU) Acceleration() (ax, ay, az int32) {
+	ax, ay, az = a.IMU.Acceleration()
+	return roundVec(a.mount.MulVec(scaledVecFromInt(1, ax, ay, az)))
+}
+
+func (a alignedIMU) AngularVelocity() (gx, gy, gz int32) {
+	gx, gy, gz = a.IMU.AngularVelocity()
+	return roundVec(a.mount.MulVec(scaledVecFromInt(1, gx, gy, gz)))
+}
+
+type alignedIMUHeading struct {
+	alignedIMU
+	mag Magnetometer
+}
+
+func (a alignedIMUHeading) North() (mx, my, mz int32) {
+	mx, my, mz = a.mag.North()
+	return roundVec(a.mount.MulVec(scaledVecFromInt(1, mx, my, mz)))
+}
@@ -0,0 +1,61 @@
+package ahrs
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/num/quat"
+)
+
+func TestAxesAlignment(t *testing.T) {
+	seen := make(map[Matrix3]bool)
+	for a := AxesPXPYPZ; a < axesLen; a++ {
+		m := a.Matrix()
+		if seen[m] {
+			t.Errorf("%v: duplicate alignment", a)
+		}
+		seen[m] = true
+		if det := m.Det(); det != 1 {
+			t.Errorf("%v: expected rotation with determinant 1, got %g", a, det)
+		}
+		inv, err := m.Inverse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				if inv[i][j] != m[j][i] {
+					t.Fatalf("%v: matrix not orthogonal", a)
+				}
+			}
+		}
+	}
+
+	// Sensor mounted rotated 90 degrees about Z.
+	yaw90 := quat.Number{Real: math.Cos(math.Pi / 4), Kmag: math.Sin(math.Pi / 4)}
+	got := MountFromQuat(yaw90)
+	expect := AxesNYPXPZ.Matrix()
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if math.Abs(got[i][j]-expect[i][j]) > 1e-12 {
+				t.Fatalf("expected %v from quaternion mount, got %v", expect, got)
+			}
+		}
+	}
+
+	// Upside down sensor.
+	imu := &testIMU{accel: [3]int32{0, 0, -1e6}, gyro: [3]int32{1, 2, 3}, north: [3]int32{20000, 100, 45000}}
+	aligned := NewAlignedIMUHeading(imu, AxesPXNYNZ.Matrix())
+	if ax, ay, az := aligned.Acceleration(); ax != 0 || ay != 0 || az != 1e6 {
+		t.Errorf("unexpected aligned acceleration %d %d %d", ax, ay, az)
+	}
+	if gx, gy, gz := aligned.AngularVelocity(); gx != 1 || gy != -2 || gz != -3 {
+		t.Errorf("unexpected aligned angular velocity %d %d %d", gx, gy, gz)
+	}
+	if mx, my, mz := aligned.North(); mx != 20000 || my != -100 || mz != -45000 {
+		t.Errorf("unexpected aligned magnetic field %d %d %d", mx, my, mz)
+	}
+	if s := AxesPXNZPY.String(); s != "+X-Z+Y" {
+		t.Errorf("unexpected alignment string %q", s)
+	}
+}
@@ -0,0 +1,116 @@
+package calibration
+
+import (
+	"errors"
+	"math"
+
+	"github.com/soypat/ahrs"
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// microG is one gravity in the units returned by IMU.Acceleration.
+const microG = 1e6
+
+// AccelCalibration is an accelerometer bias, scale and misalignment correction.
+// A raw reading a in micro gravities is corrected by Correction*(a - Bias).
+type AccelCalibration struct {
+	// Bias is the accelerometer zero-g offset in micro gravities.
+	Bias r3.Vec
+	// Correction corrects per axis scale and cross-axis misalignment.
+	Correction ahrs.Matrix3
+}
+
+// Apply returns the corrected accelerometer reading in micro gravities.
+func (c AccelCalibration) Apply(a r3.Vec) r3.Vec {
+	return c.Correction.MulVec(r3.Sub(a, c.Bias))
+}
+
+// CalibrateAccelSixPosition solves accelerometer bias, scale and misalignment from
+// averaged readings in micro gravities taken in six axis aligned static orientations,
+// i.e. obtained with ahrs.AverageAcceleration.
+// The readings are ordered by the sensor axis pointing up: +X, -X, +Y, -Y, +Z, -Z.
+func CalibrateAccelSixPosition(readings [6]r3.Vec) (AccelCalibration, error) {
+	truth := [6]r3.Vec{
+		{X: microG}, {X: -microG},
+		{Y: microG}, {Y: -microG},
+		{Z: microG}, {Z: -microG},
+	}
+	// Solve raw = C*truth + Bias by least squares for each raw axis.
+	D := mat.NewDense(6, 4, nil)
+	raw := mat.NewDense(6, 3, nil)
+	for k := range readings {
+		t := r3.Scale(1/microG, truth[k])
+		D.SetRow(k, []float64{t.X, t.Y, t.Z, 1})
+		raw.SetRow(k, []float64{readings[k].X, readings[k].Y, readings[k].Z})
+	}
+	var sol mat.Dense
+	if err := sol.Solve(D, raw); err != nil {
+		return AccelCalibration{}, err
+	}
+	var C ahrs.Matrix3
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			// sol is 4x3, row j is the truth component, column i the raw axis.
+			C[i][j] = sol.At(j, i) / microG
+		}
+	}
+	correction, err := C.Inverse()
+	if err != nil {
+		return AccelCalibration{}, errors.New("accelerometer readings are degenerate")
+	}
+	return AccelCalibration{
+		Bias:       r3.Vec{X: sol.At(3, 0), Y: sol.At(3, 1), Z: sol.At(3, 2)},
+		Correction: correction,
+	}, nil
+}
+
+// CalibrateAccelStatic solves accelerometer bias and scale from averaged readings
+// in micro gravities taken in arbitrary static orientations by fitting an ellipsoid.
+// At least nine well distributed orientations are required. Since the orientations
+// are unknown only the symmetric part of the misalignment can be recovered.
+func CalibrateAccelStatic(readings []r3.Vec) (AccelCalibration, error) {
+	fit, err := fitEllipsoid(readings)
+	if err != nil {
+		return AccelCalibration{}, err
+	}
+	scale := microG / fit.FieldStrength
+	cal := AccelCalibration{Bias: fit.HardIron}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			cal.Correction[i][j] = scale * fit.SoftIron[i][j]
+		}
+	}
+	return cal, nil
+}
+
+// NewAccelCalibratedIMU returns an IMU which applies cal to
+// the accelerometer readings of imu.
+func NewAccelCalibratedIMU(imu ahrs.IMU, cal AccelCalibration) ahrs.IMU {
+	if imu == nil {
+		panic("nil IMU in NewAccelCalibratedIMU")
+	}
+	return accelCalibratedIMU{IMU: imu, cal: cal}
+}
+
+type accelCalibratedIMU struct {
+	ahrs.IMU
+	cal AccelCalibration
+}
+
+// Acceleration returns the corrected acceleration in micro gravities.
+func (a accelCalibratedIMU) Acceleration() (ax, ay, az int32) {
+	ax, ay, az = a.IMU.Acceleration()
+	return roundVec(a.cal.Apply(scaledVecFromInt(1, ax, ay, az)))
+}
+
+// Residual returns the root mean square deviation of the magnitude of corrected
+// static readings from one gravity in micro gravities. It is a measure of calibration quality.
+func (c AccelCalibration) Residual(readings []r3.Vec) float64 {
+	var sumSq float64
+	for _, r := range readings {
+		d := r3.Norm(c.Apply(r)) - microG
+		sumSq += d * d
+	}
+	return math.Sqrt(sumSq / float64(len(readings)))
+}
@@ -0,0 +1,73 @@
+package calibration
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/ahrs"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestCalibrateAccelSixPosition(t *testing.T) {
+	const tol = 1e-9
+	// Sensor model raw = distortion*true + bias.
+	distortion := ahrs.Matrix3{
+		{1.02, 0.01, -0.02},
+		{-0.005, 0.97, 0.015},
+		{0.01, 0.02, 1.05},
+	}
+	bias := r3.Vec{X: 20000, Y: -15000, Z: 40000}
+	var readings [6]r3.Vec
+	for k, up := range []r3.Vec{{X: 1}, {X: -1}, {Y: 1}, {Y: -1}, {Z: 1}, {Z: -1}} {
+		readings[k] = r3.Add(distortion.MulVec(r3.Scale(microG, up)), bias)
+	}
+	cal, err := CalibrateAccelSixPosition(readings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := r3.Norm(r3.Sub(cal.Bias, bias)); d > tol*microG {
+		t.Errorf("expected bias %v, got %v", bias, cal.Bias)
+	}
+	product := cal.Correction.Mul(distortion)
+	identity := ahrs.Identity3()
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if math.Abs(product[i][j]-identity[i][j]) > tol {
+				t.Fatalf("correction does not invert distortion: %v", product)
+			}
+		}
+	}
+
+	imu := &testIMU{}
+	imu.accel[0], imu.accel[1], imu.accel[2] = roundVec(readings[4])
+	ax, ay, az := NewAccelCalibratedIMU(imu, cal).Acceleration()
+	if ax != 0 || ay != 0 || az != microG {
+		t.Errorf("expected calibrated 1g on z, got %d %d %d", ax, ay, az)
+	}
+}
+
+func TestCalibrateAccelStatic(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	distortion := ahrs.Matrix3{
+		{1.02, 0.01, -0.02},
+		{0.01, 0.97, 0.015},
+		{-0.02, 0.015, 1.05},
+	}
+	bias := r3.Vec{X: 20000, Y: -15000, Z: 40000}
+	var readings []r3.Vec
+	for i := 0; i < 30; i++ {
+		up := r3.Unit(r3.Vec{X: rng.NormFloat64(), Y: rng.NormFloat64(), Z: rng.NormFloat64()})
+		readings = append(readings, r3.Add(distortion.MulVec(r3.Scale(microG, up)), bias))
+	}
+	cal, err := CalibrateAccelStatic(readings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := r3.Norm(r3.Sub(cal.Bias, bias)); d > 1 {
+		t.Errorf("expected bias %v, got %v", bias, cal.Bias)
+	}
+	if res := cal.Residual(readings); res > 1 {
+		t.Errorf("expected negligible residual, got %g micro g", res)
+	}
+}
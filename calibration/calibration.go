@@ -0,0 +1,133 @@
+// Package calibration fits and applies accelerometer, gyroscope and magnetometer
+// corrections. The calibrated IMU wrappers are drop-in sensors for the
+// estimators of package ahrs.
+package calibration
+
+import (
+	"encoding/json"
+
+	"github.com/soypat/ahrs"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// InertialCalibration is a gyroscope or accelerometer calibration model.
+// A raw reading is corrected by Misalignment*((raw - Offset) ⊙ Sensitivity)
+// where ⊙ is the element-wise product. Same model as FusionCalibrationInertial
+// in xioTechnologies/Fusion.
+type InertialCalibration struct {
+	// Misalignment corrects cross-axis misalignment.
+	Misalignment ahrs.Matrix3 `json:"misalignment"`
+	// Sensitivity is the per axis scale factor.
+	Sensitivity r3.Vec `json:"sensitivity"`
+	// Offset is the per axis zero offset in raw sensor units.
+	Offset r3.Vec `json:"offset"`
+}
+
+// NewInertialCalibration returns the identity calibration which
+// leaves readings unchanged.
+func NewInertialCalibration() InertialCalibration {
+	return InertialCalibration{
+		Misalignment: ahrs.Identity3(),
+		Sensitivity:  r3.Vec{X: 1, Y: 1, Z: 1},
+	}
+}
+
+// UnmarshalJSON decodes c from JSON. Fields missing from the JSON object
+// keep the identity calibration instead of the zero value, which would zero readings.
+func (c *InertialCalibration) UnmarshalJSON(b []byte) error {
+	type plain InertialCalibration // Avoid recursion into UnmarshalJSON.
+	cal := plain(NewInertialCalibration())
+	if err := json.Unmarshal(b, &cal); err != nil {
+		return err
+	}
+	*c = InertialCalibration(cal)
+	return nil
+}
+
+// Apply returns the calibrated reading.
+func (c InertialCalibration) Apply(raw r3.Vec) r3.Vec {
+	v := r3.Sub(raw, c.Offset)
+	v = r3.Vec{X: v.X * c.Sensitivity.X, Y: v.Y * c.Sensitivity.Y, Z: v.Z * c.Sensitivity.Z}
+	return c.Misalignment.MulVec(v)
+}
+
+// Inertial returns the accelerometer calibration as an InertialCalibration.
+func (c AccelCalibration) Inertial() InertialCalibration {
+	return InertialCalibration{
+		Misalignment: c.Correction,
+		Sensitivity:  r3.Vec{X: 1, Y: 1, Z: 1},
+		Offset:       c.Bias,
+	}
+}
+
+// SensorCalibration holds the calibration of all sensors of an IMU. It
+// can be serialized to JSON to persist calibrations between runs.
+type SensorCalibration struct {
+	Gyro  InertialCalibration `json:"gyro"`
+	Accel InertialCalibration `json:"accel"`
+	// Mag is the magnetometer calibration. If nil magnetometer readings are not modified.
+	Mag *MagCalibration `json:"mag,omitempty"`
+}
+
+// NewSensorCalibration returns the identity calibration which leaves readings unchanged.
+func NewSensorCalibration() SensorCalibration {
+	return SensorCalibration{
+		Gyro:  NewInertialCalibration(),
+		Accel: NewInertialCalibration(),
+	}
+}
+
+// UnmarshalJSON decodes c from JSON. Sensors missing from the JSON object
+// keep the identity calibration.
+func (c *SensorCalibration) UnmarshalJSON(b []byte) error {
+	type plain SensorCalibration // Avoid recursion into UnmarshalJSON.
+	cal := plain(NewSensorCalibration())
+	if err := json.Unmarshal(b, &cal); err != nil {
+		return err
+	}
+	*c = SensorCalibration(cal)
+	return nil
+}
+
+// NewCalibratedIMU returns an IMU which applies the accelerometer and
+// gyroscope calibration to the readings of imu. Readings are calibrated in
+// the raw IMU units (micro gravities and micro radians per second).
+func NewCalibratedIMU(imu ahrs.IMU, cal SensorCalibration) ahrs.IMU {
+	if imu == nil {
+		panic("nil IMU in NewCalibratedIMU")
+	}
+	return calibratedIMU{IMU: imu, cal: cal}
+}
+
+// NewCalibratedIMUHeading returns an IMUHeading which applies the accelerometer,
+// gyroscope and magnetometer calibration to the readings of imu.
+func NewCalibratedIMUHeading(imu ahrs.IMUHeading, cal SensorCalibration) ahrs.IMUHeading {
+	if imu == nil {
+		panic("nil IMU in NewCalibratedIMUHeading")
+	}
+	var magnetometer ahrs.Magnetometer = imu
+	if cal.Mag != nil {
+		magnetometer = magCalibratedIMU{IMUHeading: imu, cal: *cal.Mag}
+	}
+	return ahrs.NewIMUHeading(calibratedIMU{IMU: imu, cal: cal}, magnetometer)
+}
+
+type calibratedIMU struct {
+	ahrs.IMU
+	cal SensorCalibration
+}
+
+func (c calibratedIMU) Acceleration() (ax, ay, az int32) {
+	ax, ay, az = c.IMU.Acceleration()
+	return roundVec(c.cal.Accel.Apply(scaledVecFromInt(1, ax, ay, az)))
+}
+
+func (c calibratedIMU) AngularVelocity() (gx, gy, gz int32) {
+	gx, gy, gz = c.IMU.AngularVelocity()
+	return roundVec(c.cal.Gyro.Apply(scaledVecFromInt(1, gx, gy, gz)))
+}
+
+// scaledVecFromInt returns the integer sensor reading x, y, z multiplied by scale.
+func scaledVecFromInt(scale float64, x, y, z int32) r3.Vec {
+	return r3.Vec{X: scale * float64(x), Y: scale * float64(y), Z: scale * float64(z)}
+}
@@ -0,0 +1,80 @@
+package calibration
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/soypat/ahrs"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestSensorCalibration(t *testing.T) {
+	cal := NewSensorCalibration()
+	cal.Gyro.Offset = r3.Vec{X: 1000, Y: -2000, Z: 500}
+	cal.Accel.Sensitivity = r3.Vec{X: 1, Y: 1, Z: 0.5}
+	cal.Accel.Misalignment = ahrs.Matrix3{{0, 1, 0}, {1, 0, 0}, {0, 0, 1}}
+	cal.Mag = &MagCalibration{HardIron: r3.Vec{X: 100}, SoftIron: ahrs.Identity3()}
+
+	b, err := json.Marshal(cal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got SensorCalibration
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cal) {
+		t.Fatalf("JSON round trip mismatch:\n%+v\n%+v", cal, got)
+	}
+
+	imu := &testIMU{
+		accel: [3]int32{100, 200, 2e6},
+		gyro:  [3]int32{1000, -2000, 500},
+		north: [3]int32{100, 0, -40000},
+	}
+	calibrated := NewCalibratedIMUHeading(imu, got)
+	if ax, ay, az := calibrated.Acceleration(); ax != 200 || ay != 100 || az != 1e6 {
+		t.Errorf("unexpected calibrated acceleration %d %d %d", ax, ay, az)
+	}
+	if gx, gy, gz := calibrated.AngularVelocity(); gx != 0 || gy != 0 || gz != 0 {
+		t.Errorf("unexpected calibrated angular velocity %d %d %d", gx, gy, gz)
+	}
+	if mx, my, mz := calibrated.North(); mx != 0 || my != 0 || mz != -40000 {
+		t.Errorf("unexpected calibrated magnetic field %d %d %d", mx, my, mz)
+	}
+	// Identity calibration leaves readings untouched.
+	identity := NewCalibratedIMU(imu, NewSensorCalibration())
+	if ax, ay, az := identity.Acceleration(); ax != 100 || ay != 200 || az != 2e6 {
+		t.Errorf("identity calibration modified acceleration: %d %d %d", ax, ay, az)
+	}
+}
+
+func TestSensorCalibrationPartialJSON(t *testing.T) {
+	var got SensorCalibration
+	if err := json.Unmarshal([]byte(`{"accel":{"offset":{"X":1,"Y":2,"Z":3}},"mag":{"hard_iron":{"X":100,"Y":0,"Z":0}}}`), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := NewSensorCalibration()
+	want.Accel.Offset = r3.Vec{X: 1, Y: 2, Z: 3}
+	want.Mag = &MagCalibration{HardIron: r3.Vec{X: 100}, SoftIron: ahrs.Identity3()}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("missing fields must default to identity:\n%+v\n%+v", want, got)
+	}
+}
+
+type testIMU struct {
+	accel, gyro, north [3]int32
+}
+
+func (t *testIMU) Acceleration() (ax, ay, az int32) {
+	return t.accel[0], t.accel[1], t.accel[2]
+}
+
+func (t *testIMU) AngularVelocity() (gx, gy, gz int32) {
+	return t.gyro[0], t.gyro[1], t.gyro[2]
+}
+
+func (t *testIMU) North() (mx, my, mz int32) {
+	return t.north[0], t.north[1], t.north[2]
+}
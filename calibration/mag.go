@@ -0,0 +1,187 @@
+package calibration
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+
+	"github.com/soypat/ahrs"
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// MagCalibration is a magnetometer hard iron and soft iron correction.
+// A raw reading m is corrected by SoftIron*(m - HardIron).
+type MagCalibration struct {
+	// HardIron is the offset of the measured field ellipsoid center in sensor units.
+	HardIron r3.Vec `json:"hard_iron"`
+	// SoftIron maps offset corrected readings onto a sphere.
+	SoftIron ahrs.Matrix3 `json:"soft_iron"`
+	// FieldStrength is the radius of the corrected sphere in sensor units.
+	FieldStrength float64 `json:"field_strength"`
+	// FitError is the root mean square deviation of corrected reading
+	// magnitudes from FieldStrength divided by FieldStrength. Values
+	// above a few percent usually indicate poor coverage or disturbances.
+	FitError float64 `json:"fit_error"`
+}
+
+// UnmarshalJSON decodes c from JSON. A missing soft_iron field
+// defaults to the identity matrix instead of the zero matrix.
+func (c *MagCalibration) UnmarshalJSON(b []byte) error {
+	type plain MagCalibration // Avoid recursion into UnmarshalJSON.
+	cal := plain{SoftIron: ahrs.Identity3()}
+	if err := json.Unmarshal(b, &cal); err != nil {
+		return err
+	}
+	*c = MagCalibration(cal)
+	return nil
+}
+
+// Apply returns the corrected magnetometer reading.
+func (c MagCalibration) Apply(m r3.Vec) r3.Vec {
+	return c.SoftIron.MulVec(r3.Sub(m, c.HardIron))
+}
+
+// MagCalibrator collects magnetometer readings and fits an ellipsoid to them
+// to find hard iron and soft iron distortion. Readings should be collected while
+// rotating the sensor through as many orientations as possible.
+type MagCalibrator struct {
+	samples []r3.Vec
+}
+
+// Add adds a magnetometer reading.
+func (c *MagCalibrator) Add(m r3.Vec) {
+	c.samples = append(c.samples, m)
+}
+
+// Read reads the magnetometer and adds the reading in nanoteslas.
+func (c *MagCalibrator) Read(magnetometer ahrs.Magnetometer) {
+	mx, my, mz := magnetometer.North()
+	c.Add(scaledVecFromInt(1, mx, my, mz))
+}
+
+// Len returns the amount of collected readings.
+func (c *MagCalibrator) Len() int { return len(c.samples) }
+
+// Fit fits an ellipsoid to the collected readings by least squares and
+// returns the correction that maps it onto a sphere.
+func (c *MagCalibrator) Fit() (MagCalibration, error) {
+	return fitEllipsoid(c.samples)
+}
+
+func fitEllipsoid(samples []r3.Vec) (MagCalibration, error) {
+	const params = 9
+	n := len(samples)
+	if n < params {
+		return MagCalibration{}, errors.New("not enough magnetometer samples to fit ellipsoid")
+	}
+	// Normalize samples for numerical conditioning.
+	var mean r3.Vec
+	for _, s := range samples {
+		mean = r3.Add(mean, s)
+	}
+	mean = r3.Scale(1/float64(n), mean)
+	var scale float64
+	for _, s := range samples {
+		scale += r3.Norm2(r3.Sub(s, mean))
+	}
+	scale = math.Sqrt(scale / float64(n))
+	if scale == 0 {
+		return MagCalibration{}, errors.New("magnetometer samples are all equal")
+	}
+
+	// Solve a*x² + b*y² + c*z² + 2d*xy + 2e*xz + 2f*yz + 2g*x + 2h*y + 2i*z = 1.
+	D := mat.NewDense(n, params, nil)
+	ones := mat.NewVecDense(n, nil)
+	for i, s := range samples {
+		u := r3.Scale(1/scale, r3.Sub(s, mean))
+		D.SetRow(i, []float64{u.X * u.X, u.Y * u.Y, u.Z * u.Z, 2 * u.X * u.Y, 2 * u.X * u.Z, 2 * u.Y * u.Z, 2 * u.X, 2 * u.Y, 2 * u.Z})
+		ones.SetVec(i, 1)
+	}
+	var v mat.VecDense
+	if err := v.SolveVec(D, ones); err != nil {
+		return MagCalibration{}, err
+	}
+	A := mat.NewSymDense(3, []float64{
+		v.AtVec(0), v.AtVec(3), v.AtVec(4),
+		v.AtVec(3), v.AtVec(1), v.AtVec(5),
+		v.AtVec(4), v.AtVec(5), v.AtVec(2),
+	})
+	linear := mat.NewVecDense(3, []float64{v.AtVec(6), v.AtVec(7), v.AtVec(8)})
+
+	// Ellipsoid center is -inv(A)*linear.
+	var center mat.VecDense
+	if err := center.SolveVec(A, linear); err != nil {
+		return MagCalibration{}, err
+	}
+	center.ScaleVec(-1, &center)
+	// (u-center)ᵀ A (u-center) = 1 + centerᵀ A center.
+	k := 1 + mat.Inner(&center, A, &center)
+
+	var eig mat.EigenSym
+	if ok := eig.Factorize(A, true); !ok {
+		return MagCalibration{}, errors.New("ellipsoid eigen decomposition failed")
+	}
+	values := eig.Values(nil)
+	var vectors mat.Dense
+	eig.VectorsTo(&vectors)
+	radiusProduct := 1.0
+	for i := range values {
+		values[i] /= k
+		if values[i] <= 0 {
+			return MagCalibration{}, errors.New("magnetometer samples do not fit an ellipsoid")
+		}
+		radiusProduct /= math.Sqrt(values[i])
+	}
+	// Radius of sphere with same volume as ellipsoid.
+	radius := math.Cbrt(radiusProduct)
+
+	// SoftIron = radius * V * sqrt(diag(values)) * Vᵀ.
+	var cal MagCalibration
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			var sum float64
+			for l := 0; l < 3; l++ {
+				sum += vectors.At(i, l) * math.Sqrt(values[l]) * vectors.At(j, l)
+			}
+			cal.SoftIron[i][j] = radius * sum
+		}
+	}
+	// Undo normalization. SoftIron is invariant to scale
+	// since it maps onto a sphere of radius*scale.
+	cal.HardIron = r3.Add(mean, r3.Scale(scale, r3.Vec{X: center.AtVec(0), Y: center.AtVec(1), Z: center.AtVec(2)}))
+	cal.FieldStrength = radius * scale
+
+	var sumSq float64
+	for _, s := range samples {
+		d := r3.Norm(cal.Apply(s)) - cal.FieldStrength
+		sumSq += d * d
+	}
+	cal.FitError = math.Sqrt(sumSq/float64(n)) / cal.FieldStrength
+	return cal, nil
+}
+
+// NewMagCalibratedIMU returns an IMUHeading which applies cal
+// to the magnetometer readings of imu.
+func NewMagCalibratedIMU(imu ahrs.IMUHeading, cal MagCalibration) ahrs.IMUHeading {
+	if imu == nil {
+		panic("nil IMU in NewMagCalibratedIMU")
+	}
+	return magCalibratedIMU{IMUHeading: imu, cal: cal}
+}
+
+type magCalibratedIMU struct {
+	ahrs.IMUHeading
+	cal MagCalibration
+}
+
+// North returns the corrected magnetic field in nanoteslas.
+func (m magCalibratedIMU) North() (mx, my, mz int32) {
+	mx, my, mz = m.IMUHeading.North()
+	return roundVec(m.cal.Apply(scaledVecFromInt(1, mx, my, mz)))
+}
+
+// roundVec rounds the components of v to the nearest integer.
+func roundVec(v r3.Vec) (x, y, z int32) {
+	return int32(math.Round(v.X)), int32(math.Round(v.Y)), int32(math.Round(v.Z))
+}
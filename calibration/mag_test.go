@@ -0,0 +1,78 @@
+package calibration
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/ahrs"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestMagCalibrationDistortedSphere(t *testing.T) {
+	const (
+		field = 50000 // nT
+		noise = 50    // nT
+		n     = 1000
+	)
+	rng := rand.New(rand.NewSource(1))
+	softIron := ahrs.Matrix3{
+		{1.2, 0.1, -0.05},
+		{0.1, 0.9, 0.08},
+		{-0.05, 0.08, 1.05},
+	}
+	hardIron := r3.Vec{X: 12000, Y: -7000, Z: 3000}
+	var cal MagCalibrator
+	var imu testIMU
+	for i := 0; i < n; i++ {
+		dir := r3.Unit(r3.Vec{X: rng.NormFloat64(), Y: rng.NormFloat64(), Z: rng.NormFloat64()})
+		m := r3.Add(softIron.MulVec(r3.Scale(field, dir)), hardIron)
+		m = r3.Add(m, r3.Scale(noise, r3.Vec{X: rng.NormFloat64(), Y: rng.NormFloat64(), Z: rng.NormFloat64()}))
+		imu.north[0], imu.north[1], imu.north[2] = roundVec(m)
+		cal.Read(&imu)
+	}
+	got, err := cal.Fit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := r3.Norm(r3.Sub(got.HardIron, hardIron)); d > 5*noise {
+		t.Errorf("expected hard iron %v, got %v", hardIron, got.HardIron)
+	}
+	if got.FitError > 2.0*noise/field {
+		t.Errorf("fit error too large: %g", got.FitError)
+	}
+	// Correction times distortion should be the identity scaled to the fitted field strength.
+	scale := got.FieldStrength / field
+	product := got.SoftIron.Mul(softIron)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			expect := 0.0
+			if i == j {
+				expect = scale
+			}
+			if math.Abs(product[i][j]-expect) > 5e-3 {
+				t.Errorf("soft iron correction times distortion (%d,%d): expected %g, got %g", i, j, expect, product[i][j])
+			}
+		}
+	}
+
+	// Wrapped IMU readings lie on a sphere.
+	corrected := NewMagCalibratedIMU(&imu, got)
+	mx, my, mz := corrected.North()
+	if norm := r3.Norm(scaledVecFromInt(1, mx, my, mz)); math.Abs(norm-got.FieldStrength) > 5*noise {
+		t.Errorf("expected corrected field strength %g, got %g", got.FieldStrength, norm)
+	}
+
+	// Pure sphere samples have no distortion.
+	var sphere MagCalibrator
+	for i := 0; i < n; i++ {
+		sphere.Add(r3.Scale(field, r3.Unit(r3.Vec{X: rng.NormFloat64(), Y: rng.NormFloat64(), Z: rng.NormFloat64()})))
+	}
+	got, err = sphere.Fit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r3.Norm(got.HardIron) > 1e-6*field || math.Abs(got.FieldStrength-field) > 1e-6*field || got.FitError > 1e-9 {
+		t.Errorf("expected undistorted calibration, got %+v", got)
+	}
+}
@@ -0,0 +1,233 @@
+package calibration
+
+import (
+	"math"
+
+	"github.com/soypat/ahrs"
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+const (
+	// Direction bins: 6 cube faces each split in 4 quadrants.
+	onlineMagBins = 24
+	// Readings a bin must accumulate before it counts towards coverage.
+	onlineMagMinBinSamples = 5
+	// Maximum weight of the running average of a bin. Older readings are
+	// gradually forgotten so the calibration can track slow changes.
+	onlineMagMaxBinWeight = 100
+	// Readings between refit attempts.
+	onlineMagRefitInterval = 50
+)
+
+// OnlineMagCalibrator estimates the magnetometer hard iron offset during
+// normal operation. Readings are binned by direction so that no single
+// orientation dominates the fit. A new calibration is only swapped in when
+// enough directions are covered and the sphere fit residual is small.
+type OnlineMagCalibrator struct {
+	// MinCoverage is the fraction of direction bins that must contain
+	// readings before a calibration is accepted.
+	MinCoverage float64
+	// MaxFitError is the maximum normalized fit residual (see MagCalibration.FitError)
+	// for a calibration to be accepted.
+	MaxFitError float64
+
+	imu     ahrs.IMUHeading
+	bins    [onlineMagBins]magBin
+	cal     MagCalibration
+	fitted  bool
+	pending int
+	// Extent of the raw readings, its midpoint centers the direction bins
+	// until the first calibration is accepted.
+	lo, hi r3.Vec
+}
+
+// magBin holds the running average of the sphere fit normal equation terms
+// of the readings in one direction, see fitSphere.
+type magBin struct {
+	ata    [4][4]float64
+	atb    [4]float64
+	btb    float64
+	weight float64
+}
+
+func (b *magBin) add(m r3.Vec) {
+	if b.weight < onlineMagMaxBinWeight {
+		b.weight++
+	}
+	alpha := 1 / b.weight
+	a := [4]float64{2 * m.X, 2 * m.Y, 2 * m.Z, 1}
+	rhs := r3.Norm2(m)
+	for i := range a {
+		for j := range a {
+			b.ata[i][j] += alpha * (a[i]*a[j] - b.ata[i][j])
+		}
+		b.atb[i] += alpha * (a[i]*rhs - b.atb[i])
+	}
+	b.btb += alpha * (rhs*rhs - b.btb)
+}
+
+// NewOnlineMagCalibrator returns an online magnetometer calibrator
+// with identity calibration and default acceptance thresholds.
+// The calibrator is an IMUHeading which reads imu, so it may be placed between
+// a sensor driver and an estimator:
+//
+//	cal := calibration.NewOnlineMagCalibrator(sensor)
+//	estimator := ahrs.NewXioAHRS(0.5, cal)
+//
+// imu may be nil if readings are fed through Update.
+func NewOnlineMagCalibrator(imu ahrs.IMUHeading) *OnlineMagCalibrator {
+	return &OnlineMagCalibrator{
+		MinCoverage: 0.75,
+		MaxFitError: 0.05,
+		imu:         imu,
+		cal:         MagCalibration{SoftIron: ahrs.Identity3()},
+	}
+}
+
+// Acceleration returns the accelerometer readings of the underlying IMU.
+func (c *OnlineMagCalibrator) Acceleration() (ax, ay, az int32) {
+	return c.imu.Acceleration()
+}
+
+// AngularVelocity returns the gyroscope readings of the underlying IMU.
+func (c *OnlineMagCalibrator) AngularVelocity() (gx, gy, gz int32) {
+	return c.imu.AngularVelocity()
+}
+
+// North reads the underlying magnetometer, updates the calibration
+// and returns the corrected reading in nanoteslas.
+func (c *OnlineMagCalibrator) North() (mx, my, mz int32) {
+	mx, my, mz = c.imu.North()
+	return roundVec(c.Update(scaledVecFromInt(1, mx, my, mz)))
+}
+
+// Update adds a raw magnetometer reading to the calibration data, refits the
+// hard iron offset periodically and returns the reading corrected with the current calibration.
+func (c *OnlineMagCalibrator) Update(m r3.Vec) r3.Vec {
+	if m == (r3.Vec{}) {
+		return m
+	}
+	c.bins[magBinIndex(r3.Sub(m, c.binCenter(m)))].add(m)
+	c.pending++
+	if c.pending >= onlineMagRefitInterval {
+		c.pending = 0
+		c.refit()
+	}
+	return c.cal.Apply(m)
+}
+
+// binCenter records m and returns the center around which readings are binned by
+// direction. Before the first fit the hard iron offset may exceed the field strength
+// so that binning around the origin would leave most bins empty, the midpoint of
+// the readings' extent is used instead.
+func (c *OnlineMagCalibrator) binCenter(m r3.Vec) r3.Vec {
+	if c.fitted {
+		return c.cal.HardIron
+	}
+	if c.lo == (r3.Vec{}) && c.hi == (r3.Vec{}) {
+		c.lo, c.hi = m, m
+	}
+	c.lo = r3.Vec{X: math.Min(c.lo.X, m.X), Y: math.Min(c.lo.Y, m.Y), Z: math.Min(c.lo.Z, m.Z)}
+	c.hi = r3.Vec{X: math.Max(c.hi.X, m.X), Y: math.Max(c.hi.Y, m.Y), Z: math.Max(c.hi.Z, m.Z)}
+	return r3.Scale(0.5, r3.Add(c.lo, c.hi))
+}
+
+// Calibration returns the calibration currently applied to readings.
+func (c *OnlineMagCalibrator) Calibration() MagCalibration { return c.cal }
+
+// Coverage returns the fraction of direction bins which contain enough readings.
+func (c *OnlineMagCalibrator) Coverage() float64 {
+	filled := 0
+	for _, b := range c.bins {
+		if b.weight >= onlineMagMinBinSamples {
+			filled++
+		}
+	}
+	return float64(filled) / onlineMagBins
+}
+
+// refit fits a sphere to the binned readings and swaps the calibration in if acceptance thresholds pass.
+func (c *OnlineMagCalibrator) refit() {
+	if c.Coverage() < c.MinCoverage {
+		return
+	}
+	var filled []*magBin
+	for i := range c.bins {
+		if c.bins[i].weight >= onlineMagMinBinSamples {
+			filled = append(filled, &c.bins[i])
+		}
+	}
+	cal, ok := fitSphere(filled)
+	if ok && cal.FitError <= c.MaxFitError {
+		c.cal = cal
+		c.fitted = true
+	}
+}
+
+// fitSphere fits a sphere by linear least squares solving
+// |m|² = 2*m·center + radius² - |center|² for every reading m.
+// Each bin contributes with equal weight regardless of how many readings it holds.
+// The fit error is approximated from the algebraic residual, which for small errors
+// is 2*radius times the geometric residual.
+func fitSphere(bins []*magBin) (cal MagCalibration, ok bool) {
+	if len(bins) < 4 {
+		return cal, false
+	}
+	ata := mat.NewSymDense(4, nil)
+	atb := mat.NewVecDense(4, nil)
+	var btb float64
+	for _, b := range bins {
+		for i := 0; i < 4; i++ {
+			for j := i; j < 4; j++ {
+				ata.SetSym(i, j, ata.At(i, j)+b.ata[i][j])
+			}
+			atb.SetVec(i, atb.AtVec(i)+b.atb[i])
+		}
+		btb += b.btb
+	}
+	var x mat.VecDense
+	if err := x.SolveVec(ata, atb); err != nil {
+		return cal, false
+	}
+	center := r3.Vec{X: x.AtVec(0), Y: x.AtVec(1), Z: x.AtVec(2)}
+	radius2 := x.AtVec(3) + r3.Norm2(center)
+	if !(radius2 > 0) {
+		return cal, false
+	}
+	// Sum of squared residuals: bᵀb - 2xᵀAᵀb + xᵀAᵀAx.
+	var ax mat.VecDense
+	ax.MulVec(ata, &x)
+	sumSq := math.Max(btb-2*mat.Dot(&x, atb)+mat.Dot(&x, &ax), 0)
+	cal = MagCalibration{
+		HardIron:      center,
+		SoftIron:      ahrs.Identity3(),
+		FieldStrength: math.Sqrt(radius2),
+		FitError:      math.Sqrt(sumSq/float64(len(bins))) / (2 * radius2),
+	}
+	return cal, true
+}
+
+// magBinIndex returns the direction bin of v: the cube face of its dominant axis
+// and the quadrant given by the signs of the remaining two axes.
+func magBinIndex(v r3.Vec) int {
+	c := [3]float64{v.X, v.Y, v.Z}
+	axis := 0
+	for i := 1; i < 3; i++ {
+		if math.Abs(c[i]) > math.Abs(c[axis]) {
+			axis = i
+		}
+	}
+	face := 2 * axis
+	if c[axis] < 0 {
+		face++
+	}
+	quadrant := 0
+	if c[(axis+1)%3] < 0 {
+		quadrant |= 1
+	}
+	if c[(axis+2)%3] < 0 {
+		quadrant |= 2
+	}
+	return 4*face + quadrant
+}
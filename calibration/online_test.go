@@ -0,0 +1,58 @@
+package calibration
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/soypat/ahrs"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestOnlineMagCalibrator(t *testing.T) {
+	const field = 50000 // nT
+	rng := rand.New(rand.NewSource(1))
+	hardIron := r3.Vec{X: 8000, Y: -3000, Z: 12000}
+	imu := &testIMU{accel: [3]int32{0, 0, 1e6}}
+	cal := NewOnlineMagCalibrator(imu)
+	f := ahrs.NewXioAHRS(0.5, cal)
+
+	// Single orientation must not produce a calibration.
+	imu.north[0], imu.north[1], imu.north[2] = roundVec(r3.Add(hardIron, r3.Vec{X: field}))
+	for i := 0; i < 1000; i++ {
+		f.Update(0.01)
+	}
+	if cal.Coverage() >= cal.MinCoverage || cal.Calibration().HardIron != (r3.Vec{}) {
+		t.Fatalf("calibration accepted from one orientation: coverage %g, %+v", cal.Coverage(), cal.Calibration())
+	}
+
+	for i := 0; i < 5000; i++ {
+		dir := r3.Unit(r3.Vec{X: rng.NormFloat64(), Y: rng.NormFloat64(), Z: rng.NormFloat64()})
+		imu.north[0], imu.north[1], imu.north[2] = roundVec(r3.Add(hardIron, r3.Scale(field, dir)))
+		f.Update(0.01)
+	}
+	got := cal.Calibration()
+	if d := r3.Norm(r3.Sub(got.HardIron, hardIron)); d > 10 {
+		t.Errorf("expected hard iron %v, got %v", hardIron, got.HardIron)
+	}
+	mx, my, mz := imu.North()
+	corrected := cal.Update(scaledVecFromInt(1, mx, my, mz))
+	if d := r3.Norm(corrected) - field; d > 10 || d < -10 {
+		t.Errorf("expected corrected field strength %d, got %g", field, r3.Norm(corrected))
+	}
+}
+
+func TestOnlineMagCalibratorLargeHardIron(t *testing.T) {
+	const field = 20000 // nT
+	rng := rand.New(rand.NewSource(1))
+	// Hard iron larger than the field: all raw readings lie on one side of the origin.
+	hardIron := r3.Vec{X: 45000, Y: -20000, Z: 30000}
+	cal := NewOnlineMagCalibrator(nil)
+	for i := 0; i < 5000; i++ {
+		dir := r3.Unit(r3.Vec{X: rng.NormFloat64(), Y: rng.NormFloat64(), Z: rng.NormFloat64()})
+		cal.Update(r3.Add(hardIron, r3.Scale(field, dir)))
+	}
+	got := cal.Calibration()
+	if d := r3.Norm(r3.Sub(got.HardIron, hardIron)); d > 10 {
+		t.Errorf("expected hard iron %v, got %v (coverage %g)", hardIron, got.HardIron, cal.Coverage())
+	}
+}
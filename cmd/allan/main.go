@@ -0,0 +1,116 @@
+// Command allan prints the Allan deviation noise coefficients of each column
+// of a CSV log recorded while the IMU is static.
+//
+// The first row of the log must be a header naming the columns. A column named
+// "time" holds timestamps in seconds and is used to find the sample period
+// when -dt is not given. Every other column is analysed, i.e:
+//
+//	time,gx,gy,gz,ax,ay,az
+//	0.00,0.0012,-0.0003,0.0007,0.02,-0.01,9.81
+//
+// Usage:
+//
+//	allan [-dt period] [-ppd points] log.csv
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/soypat/ahrs"
+)
+
+func main() {
+	dt := flag.Float64("dt", 0, "sample period in seconds. If zero it is computed from the time column")
+	ppd := flag.Int("ppd", 10, "Allan deviation points per decade of cluster time")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: allan [-dt period] [-ppd points] log.csv")
+		os.Exit(2)
+	}
+	err := run(flag.Arg(0), *dt, *ppd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "allan:", err)
+		os.Exit(1)
+	}
+}
+
+func run(filename string, dt float64, ppd int) error {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	names, columns, err := readColumns(fp)
+	if err != nil {
+		return err
+	}
+	timeCol := -1
+	for i, name := range names {
+		if strings.EqualFold(name, "time") {
+			timeCol = i
+		}
+	}
+	if dt <= 0 {
+		if timeCol < 0 {
+			return errors.New("no time column in log, sample period must be set with -dt")
+		}
+		t := columns[timeCol]
+		if len(t) < 2 {
+			return errors.New("not enough samples")
+		}
+		dt = (t[len(t)-1] - t[0]) / float64(len(t)-1)
+		if !(dt > 0) {
+			return errors.New("time column is not increasing")
+		}
+	}
+	fmt.Printf("sample period %g s, %d samples (%g s)\n", dt, len(columns[0]), dt*float64(len(columns[0])))
+	fmt.Printf("%-8s %14s %14s %12s %14s\n", "column", "random walk", "bias instab.", "at tau (s)", "rate rand walk")
+	for i, name := range names {
+		if i == timeCol {
+			continue
+		}
+		noise := ahrs.AllanNoiseCoefficients(ahrs.AllanDeviation(columns[i], dt, ppd))
+		fmt.Printf("%-8s %14.6g %14.6g %12.4g %14.6g\n", name, noise.RandomWalk,
+			noise.BiasInstability, noise.BiasInstabilityTau, noise.RateRandomWalk)
+	}
+	return nil
+}
+
+// readColumns reads a CSV with a header row and returns its column names and values.
+func readColumns(r io.Reader) (names []string, columns [][]float64, err error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	names, err = cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading header: %w", err)
+	}
+	cr.FieldsPerRecord = len(names)
+	columns = make([][]float64, len(names))
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, field := range record {
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d column %q: %w", line, names[i], err)
+			}
+			columns[i] = append(columns[i], v)
+		}
+	}
+	if len(columns) == 0 || len(columns[0]) == 0 {
+		return nil, nil, errors.New("empty log")
+	}
+	return names, columns, nil
+}
@@ -0,0 +1,39 @@
+package ahrs
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// CompassHeading returns the tilt compensated magnetic heading in radians
+// from a single accelerometer and magnetometer reading. Units are irrelevant,
+// only directions are used. Heading is the yaw of the sensor in convention c:
+// counter-clockwise from north for NWU, counter-clockwise from east for ENU
+// and clockwise from north for NED. Modelled after FusionCompassCalculateHeading.
+func CompassHeading(c Convention, accel, magnet r3.Vec) float64 {
+	west := r3.Unit(r3.Cross(accel, magnet))
+	north := r3.Unit(r3.Cross(west, accel))
+	switch c {
+	case ConventionENU:
+		return math.Atan2(north.X, -west.X)
+	case ConventionNED:
+		return math.Atan2(-west.X, north.X)
+	}
+	return math.Atan2(west.X, north.X)
+}
+
+// TrueHeading returns the tilt compensated heading in radians relative to
+// true (geographic) north. declination is the magnetic declination in radians,
+// positive when magnetic north is east of true north. See CompassHeading.
+// The result is in the range [-π, π].
+func TrueHeading(c Convention, accel, magnet r3.Vec, declination float64) float64 {
+	heading := CompassHeading(c, accel, magnet)
+	if c == ConventionNED {
+		// Clockwise heading increases towards east.
+		heading += declination
+	} else {
+		heading -= declination
+	}
+	return math.Remainder(heading, 2*math.Pi)
+}
@@ -0,0 +1,49 @@
+package ahrs
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/num/quat"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestCompassHeading(t *testing.T) {
+	const (
+		tol         = 1e-9
+		declination = 0.2
+	)
+	// Sensor facing about north-east, pitched and rolled, in a field with 60° inclination.
+	earthMag := r3.Vec{X: 25000, Z: -43301} // NWU
+	attitude := quat.Mul(
+		quat.Number{Real: math.Cos(-math.Pi / 8), Kmag: math.Sin(-math.Pi / 8)},
+		NormalizeQuaternion(quat.Number{Real: 1, Imag: 0.2, Jmag: -0.15}),
+	)
+	accel := rotateVecInv(attitude, r3.Vec{Z: 1})
+	magnet := rotateVecInv(attitude, earthMag)
+	yaw := quatYaw(attitude)
+	// The NED sensor frame is the NWU sensor frame with y and z flipped.
+	expect := []struct {
+		c                     Convention
+		accel, magnet         r3.Vec
+		magnetic, trueHeading float64
+	}{
+		{ConventionNWU, accel, magnet, yaw, yaw - declination},
+		{ConventionNED, r3.Vec{X: accel.X, Y: -accel.Y, Z: -accel.Z}, r3.Vec{X: magnet.X, Y: -magnet.Y, Z: -magnet.Z}, -yaw, -yaw + declination},
+	}
+	for _, e := range expect {
+		if got := CompassHeading(e.c, e.accel, e.magnet); math.Abs(got-e.magnetic) > tol {
+			t.Errorf("%v: expected magnetic heading %g, got %g", e.c, e.magnetic, got)
+		}
+		if got := TrueHeading(e.c, e.accel, e.magnet, declination); math.Abs(got-e.trueHeading) > tol {
+			t.Errorf("%v: expected true heading %g, got %g", e.c, e.trueHeading, got)
+		}
+	}
+	// Sensor with x axis pointing north in ENU faces 90° counter-clockwise from east.
+	if got := CompassHeading(ConventionENU, r3.Vec{Z: 1}, r3.Vec{X: 20, Z: -45}); math.Abs(got-math.Pi/2) > tol {
+		t.Errorf("ENU: expected heading %g, got %g", math.Pi/2, got)
+	}
+	if got := TrueHeading(ConventionNWU, r3.Vec{Z: 1}, r3.Vec{X: -20, Z: -45}, -0.5); math.Abs(got-(0.5-math.Pi)) > tol {
+		t.Errorf("expected wrapped true heading %g, got %g", 0.5-math.Pi, got)
+	}
+}
@@ -0,0 +1,76 @@
+package ahrs
+
+import (
+	"github.com/go-gl/mathgl/mgl32"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// Convention is an earth frame axes convention.
+type Convention uint8
+
+const (
+	// ConventionNWU is the North-West-Up earth frame.
+	ConventionNWU Convention = iota
+	// ConventionENU is the East-North-Up earth frame.
+	ConventionENU
+	// ConventionNED is the North-East-Down earth frame.
+	ConventionNED
+	conventionLen
+)
+
+// String returns the convention name, i.e. "NWU".
+func (c Convention) String() string {
+	switch c {
+	case ConventionNWU:
+		return "NWU"
+	case ConventionENU:
+		return "ENU"
+	case ConventionNED:
+		return "NED"
+	}
+	return "Convention(invalid)"
+}
+
+// fromNWU returns the North-West-Up vector v expressed in convention c.
+func (c Convention) fromNWU(v r3.Vec) r3.Vec {
+	switch c {
+	case ConventionENU:
+		return r3.Vec{X: -v.Y, Y: v.X, Z: v.Z}
+	case ConventionNED:
+		return r3.Vec{X: v.X, Y: -v.Y, Z: -v.Z}
+	}
+	return v
+}
+
+// toNWU returns the vector v of convention c expressed in North-West-Up.
+func (c Convention) toNWU(v r3.Vec) r3.Vec {
+	switch c {
+	case ConventionENU:
+		return r3.Vec{X: v.Y, Y: -v.X, Z: v.Z}
+	case ConventionNED:
+		return r3.Vec{X: v.X, Y: -v.Y, Z: -v.Z}
+	}
+	return v
+}
+
+// fromNWU32 is the 32 bit version of fromNWU.
+func (c Convention) fromNWU32(v mgl32.Vec3) mgl32.Vec3 {
+	switch c {
+	case ConventionENU:
+		return mgl32.Vec3{-v[1], v[0], v[2]}
+	case ConventionNED:
+		return mgl32.Vec3{v[0], -v[1], -v[2]}
+	}
+	return v
+}
+
+// toNWU32 is the 32 bit version of toNWU.
+func (c Convention) toNWU32(v mgl32.Vec3) mgl32.Vec3 {
+	switch c {
+	case ConventionENU:
+		return mgl32.Vec3{v[1], -v[0], v[2]}
+	case ConventionNED:
+		return mgl32.Vec3{v[0], -v[1], -v[2]}
+	}
+	return v
+}
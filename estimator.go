@@ -0,0 +1,210 @@
+package ahrs
+
+import (
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"gonum.org/v1/gonum/num/quat"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// StandardGravity is the nominal gravitational acceleration in m/s².
+const StandardGravity = 9.80665
+
+// Sample is a set of inertial sensor readings in SI units.
+type Sample struct {
+	// Accel is the accelerometer reading in m/s².
+	Accel r3.Vec
+	// Gyro is the gyroscope reading in radians per second.
+	Gyro r3.Vec
+	// Mag is the magnetometer reading in teslas. The zero value
+	// means there is no magnetometer reading.
+	Mag r3.Vec
+	// Period is the time elapsed since the previous sample in seconds.
+	// If zero the period is derived from the timestamps of consecutive samples.
+	// The first timestamped sample after creation or Reset has no previous
+	// sample so it only sets the time reference and is otherwise discarded.
+	// Samples with negative or zero derived period are discarded as well.
+	Period float64
+	// Time is the timestamp of the sample relative to an arbitrary epoch.
+	// It is only used when Period is zero.
+	Time time.Duration
+}
+
+// HasMag returns true if the sample has a magnetometer reading. A sample
+// without one does not mean the sensor lacks a magnetometer, i.e. when the
+// magnetometer is sampled at a lower rate than the accelerometer and gyroscope.
+func (s Sample) HasMag() bool {
+	return s.Mag != r3.Vec{}
+}
+
+// Estimator is an attitude estimator. All estimators in this package
+// implement Estimator so that they may be used interchangeably.
+type Estimator interface {
+	// UpdateSample updates the attitude estimate with a sample. Samples
+	// without a positive period are discarded, see Sample.Period.
+	UpdateSample(s Sample)
+	// Attitude returns the estimated attitude.
+	Attitude() quat.Number
+	// SetAttitude sets the attitude estimate, i.e. to seed the estimator
+	// with the result of TRIAD or CoarseAlignment.
+	SetAttitude(q quat.Number)
+	// Reset resets the estimator state to that of a newly created estimator.
+	// Settings are preserved.
+	Reset()
+}
+
+// readSample reads a Sample in SI units from a IMU and an optional IMUHeading.
+func readSample(imu IMU, imuWithMagnetometer IMUHeading, samplePeriod float64) Sample {
+	ax, ay, az := imu.Acceleration()
+	gx, gy, gz := imu.AngularVelocity()
+	s := Sample{
+		Accel:  scaledVecFromInt(1e-6*StandardGravity, ax, ay, az),
+		Gyro:   scaledVecFromInt(1e-6, gx, gy, gz),
+		Period: samplePeriod,
+	}
+	if imuWithMagnetometer != nil {
+		mx, my, mz := imuWithMagnetometer.North()
+		s.Mag = scaledVecFromInt(1e-9, mx, my, mz)
+	}
+	return s
+}
+
+// sampleClock derives sample periods from consecutive sample timestamps.
+type sampleClock struct {
+	last  time.Duration
+	valid bool
+}
+
+// period returns the sample period of s in seconds. If s.Period is zero it is
+// the time elapsed since the previous sample. The first sample without
+// Period has a period of zero and is discarded by the estimators, as is
+// every sample read by Update(0) since they all share the zero timestamp.
+func (c *sampleClock) period(s Sample) float64 {
+	period := s.Period
+	if period == 0 && c.valid {
+		period = (s.Time - c.last).Seconds()
+	}
+	c.last = s.Time
+	c.valid = true
+	return period
+}
+
+var (
+	_ Estimator = (*XioAHRS)(nil)
+	_ Estimator = (*XioAHRS32)(nil)
+	_ Estimator = (*MadgwickFilter)(nil)
+	_ Estimator = (*MahonyFilter)(nil)
+	_ Estimator = (*MEKF)(nil)
+)
+
+// UpdateSample updates the internal quaternion with a sample. If the
+// sample has no magnetometer reading heading is not corrected on that update.
+func (f *XioAHRS) UpdateSample(s Sample) {
+	if s.Period = f.clock.period(s); s.Period <= 0 {
+		return
+	}
+	f.update(r3.Scale(1/StandardGravity, s.Accel), s.Gyro, r3.Scale(1e9, s.Mag), s.HasMag(), s.Period)
+}
+
+// Attitude returns the estimated attitude. Equivalent to GetQuaternion.
+func (f *XioAHRS) Attitude() quat.Number { return f.GetQuaternion() }
+
+// SetAttitude sets the attitude quaternion. Equivalent to SetQuaternion.
+func (f *XioAHRS) SetAttitude(q quat.Number) { f.SetQuaternion(q) }
+
+// UpdateSample updates the internal quaternion with a sample. If the
+// sample has no magnetometer reading heading is not corrected on that update.
+func (f *XioAHRS32) UpdateSample(s Sample) {
+	if s.Period = f.clock.period(s); s.Period <= 0 {
+		return
+	}
+	f.update(vec32(r3.Scale(1/StandardGravity, s.Accel)), vec32(s.Gyro), vec32(r3.Scale(1e9, s.Mag)), s.HasMag(), float32(s.Period))
+}
+
+// Attitude returns the estimated attitude as a 64 bit quaternion.
+func (f *XioAHRS32) Attitude() quat.Number {
+	q := f.GetQuaternion()
+	return quat.Number{Real: float64(q.W), Imag: float64(q.X()), Jmag: float64(q.Y()), Kmag: float64(q.Z())}
+}
+
+// SetAttitude sets the attitude from a 64 bit quaternion.
+func (f *XioAHRS32) SetAttitude(q quat.Number) {
+	f.SetQuaternion(mgl32.Quat{W: float32(q.Real), V: mgl32.Vec3{float32(q.Imag), float32(q.Jmag), float32(q.Kmag)}})
+}
+
+// UpdateSample updates the internal quaternion with a sample using
+// UpdateAHRS if the sample has a magnetometer reading or UpdateARS otherwise.
+func (mf *MadgwickFilter) UpdateSample(s Sample) {
+	if s.Period = mf.clock.period(s); s.Period <= 0 {
+		return
+	}
+	a, g, m := s.Accel, s.Gyro, s.Mag
+	mf.UpdateAHRS(a.X, a.Y, a.Z, g.X, g.Y, g.Z, m.X, m.Y, m.Z, s.Period)
+}
+
+// Attitude returns the estimated attitude. Equivalent to GetQuaternion.
+func (mf *MadgwickFilter) Attitude() quat.Number { return mf.GetQuaternion() }
+
+// SetAttitude sets the attitude quaternion.
+func (mf *MadgwickFilter) SetAttitude(q quat.Number) {
+	q = NormalizeQuaternion(q)
+	mf.Quaternion = [4]float64{q.Real, q.Imag, q.Jmag, q.Kmag}
+}
+
+// Reset sets the attitude to identity and clears the gyroscope bias estimate.
+func (mf *MadgwickFilter) Reset() {
+	mf.Quaternion = [4]float64{1, 0, 0, 0}
+	mf.gyroBias = [3]float64{}
+	mf.clock = sampleClock{}
+}
+
+// UpdateSample updates the internal quaternion with a sample using
+// UpdateAHRS if the sample has a magnetometer reading or UpdateARS otherwise.
+func (mf *MahonyFilter) UpdateSample(s Sample) {
+	if s.Period = mf.clock.period(s); s.Period <= 0 {
+		return
+	}
+	a, g, m := s.Accel, s.Gyro, s.Mag
+	mf.UpdateAHRS(a.X, a.Y, a.Z, g.X, g.Y, g.Z, m.X, m.Y, m.Z, s.Period)
+}
+
+// Attitude returns the estimated attitude. Equivalent to GetQuaternion.
+func (mf *MahonyFilter) Attitude() quat.Number { return mf.GetQuaternion() }
+
+// SetAttitude sets the attitude quaternion.
+func (mf *MahonyFilter) SetAttitude(q quat.Number) {
+	q = NormalizeQuaternion(q)
+	mf.Quaternion = [4]float64{q.Real, q.Imag, q.Jmag, q.Kmag}
+}
+
+// Reset sets the attitude to identity and clears integral feedback.
+func (mf *MahonyFilter) Reset() {
+	mf.Quaternion = [4]float64{1, 0, 0, 0}
+	mf.integralFB = [3]float64{}
+	mf.clock = sampleClock{}
+}
+
+// UpdateSample propagates and corrects the filter with a sample. If the
+// sample has no magnetometer reading heading is not corrected.
+func (k *MEKF) UpdateSample(s Sample) {
+	if s.Period = k.clock.period(s); s.Period <= 0 {
+		return
+	}
+	a, g, m := s.Accel, s.Gyro, s.Mag
+	k.UpdateAHRS(a.X, a.Y, a.Z, g.X, g.Y, g.Z, m.X, m.Y, m.Z, s.Period)
+}
+
+// Attitude returns the estimated attitude. Equivalent to GetQuaternion.
+func (k *MEKF) Attitude() quat.Number { return k.GetQuaternion() }
+
+// SetAttitude sets the attitude estimate. The covariance is left unchanged.
+func (k *MEKF) SetAttitude(q quat.Number) { k.attitude = NormalizeQuaternion(q) }
+
+// Reset sets the attitude to identity, clears the gyroscope bias
+// estimate and restores the initial covariance.
+func (k *MEKF) Reset() { k.reset() }
+
+func vec32(v r3.Vec) mgl32.Vec3 {
+	return mgl32.Vec3{float32(v.X), float32(v.Y), float32(v.Z)}
+}
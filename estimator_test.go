@@ -0,0 +1,91 @@
+package ahrs
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"gonum.org/v1/gonum/num/quat"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func testEstimators() map[string]Estimator {
+	var imu testIMU
+	return map[string]Estimator{
+		"XioAHRS":   NewXioARS(0.5, &imu),
+		"XioAHRS32": NewXioARS32(0.5, &imu),
+		"Madgwick":  NewMadgwickFilter(0.5),
+		"Mahony":    NewMahonyFilter(2, 0),
+		"MEKF": NewMEKF(MEKFNoise{
+			GyroNoise:          1e-3,
+			GyroBiasRandomWalk: 1e-5,
+			AccelNoise:         0.05,
+			MagNoise:           0.1,
+		}),
+	}
+}
+
+func TestEstimatorConvergence(t *testing.T) {
+	const (
+		dt  = 0.01
+		tol = 1 * math.Pi / 180
+	)
+	// Sensor rolled 30 degrees and facing 45 degrees west of north.
+	expect := quat.Mul(
+		quat.Number{Real: math.Cos(math.Pi / 8), Kmag: math.Sin(math.Pi / 8)},
+		quat.Number{Real: math.Cos(math.Pi / 12), Imag: math.Sin(math.Pi / 12)},
+	)
+	sample := Sample{
+		Accel:  rotateVecInv(expect, r3.Vec{Z: StandardGravity}),
+		Mag:    rotateVecInv(expect, r3.Vec{X: 20e-6, Z: -45e-6}),
+		Period: dt,
+	}
+	for name, e := range testEstimators() {
+		for i := 0; i < 3000; i++ {
+			e.UpdateSample(sample)
+		}
+		if angle := quatAngle(e.Attitude(), expect); angle > tol {
+			t.Errorf("%s: expected %v, got %v (%g rad apart)", name, expect, e.Attitude(), angle)
+		}
+		e.Reset()
+		if angle := quatAngle(e.Attitude(), quatIdentity); angle != 0 {
+			t.Errorf("%s: expected identity after Reset, got %v", name, e.Attitude())
+		}
+	}
+}
+
+// quatAngle returns the angle of the rotation between unit quaternions p and q.
+func quatAngle(p, q quat.Number) float64 {
+	d := quat.Mul(quat.Conj(p), q)
+	return 2 * math.Acos(math.Min(1, math.Abs(d.Real)))
+}
+
+func TestSampleTimestampPeriod(t *testing.T) {
+	const dt = 10 * time.Millisecond
+	sample := Sample{
+		Accel: r3.Vec{X: 1, Z: StandardGravity},
+		Gyro:  r3.Vec{X: 0.1, Y: -0.2, Z: 0.3},
+		Mag:   r3.Vec{X: 20e-6, Z: -45e-6},
+	}
+	timed := testEstimators()
+	for name, withPeriod := range testEstimators() {
+		withTime := timed[name]
+		for i := 0; i < 500; i++ {
+			s := sample
+			s.Period = dt.Seconds()
+			withPeriod.UpdateSample(s)
+			s.Period = 0
+			// First timestamped sample only sets the time reference.
+			s.Time = time.Duration(i+1)*dt + time.Hour
+			withTime.UpdateSample(s)
+		}
+		// One more timestamped sample catches up with the sample count.
+		s := sample
+		s.Time = 501*dt + time.Hour
+		withTime.UpdateSample(s)
+		// Compare components since quatAngle is ill-conditioned for float32 unit quaternions.
+		if d := quat.Abs(quat.Sub(withPeriod.Attitude(), withTime.Attitude())); d > 1e-6 {
+			t.Errorf("%s: timestamp derived period differs from explicit period: %v != %v", name, withTime.Attitude(), withPeriod.Attitude())
+		}
+	}
+}
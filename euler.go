@@ -7,6 +7,8 @@ import (
 	"gonum.org/v1/gonum/spatial/r3"
 )
 
+// EulerAngles are rotation angles in radians. The meaning of Q, R and S
+// depends on Order, see RotationOrder.
 type EulerAngles struct {
 	Q     float64
 	R     float64
@@ -31,3 +33,14 @@ func mulQuatVec(q quat.Number, v r3.Vec) (result quat.Number) {
 	result.Kmag = q.Real*v.Z + q.Imag*v.Y - q.Jmag*v.X
 	return result
 }
+
+// rotateVec rotates v by q, that is q*v*conj(q).
+func rotateVec(q quat.Number, v r3.Vec) r3.Vec {
+	r := quat.Mul(mulQuatVec(q, v), quat.Conj(q))
+	return r3.Vec{X: r.Imag, Y: r.Jmag, Z: r.Kmag}
+}
+
+// rotateVecInv rotates v by the conjugate of q, that is conj(q)*v*q.
+func rotateVecInv(q quat.Number, v r3.Vec) r3.Vec {
+	return rotateVec(quat.Conj(q), v)
+}
@@ -18,7 +18,7 @@ func (MyIMU) AngularVelocity() (gx, gy, gz int32) {
 	return 0, 0, 0 // still imu
 }
 
-func ExampleXioARS() {
+func ExampleNewXioARS() {
 	var imu MyIMU
 	estimator := ahrs.NewXioARS(1, imu)
 	dt := time.Second
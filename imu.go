@@ -21,7 +21,42 @@ type IMU interface {
 // such as Madgwick and Mahony algorithms.
 type IMUHeading interface {
 	IMU
+	Magnetometer
+}
+
+// Magnetometer represents a sensor which measures
+// the magnetic field such as the AK8963 in the MPU9250.
+type Magnetometer interface {
 	// North returns the direction of the measured magnetic field
 	// in nanoteslas.
 	North() (mx, my, mz int32)
 }
+
+// NewIMUHeading joins an IMU and a Magnetometer into an IMUHeading.
+// Useful for feeding a wrapped IMU and the magnetometer of the
+// original sensor to an AHRS.
+func NewIMUHeading(imu IMU, magnetometer Magnetometer) IMUHeading {
+	if imu == nil || magnetometer == nil {
+		panic("nil IMU or Magnetometer in NewIMUHeading")
+	}
+	return imuHeading{IMU: imu, Magnetometer: magnetometer}
+}
+
+type imuHeading struct {
+	IMU
+	Magnetometer
+}
+
+// Thermometer represents a sensor which reports its temperature,
+// usually the die temperature of an IMU.
+type Thermometer interface {
+	// Temperature returns the sensor temperature in millidegrees Celsius.
+	Temperature() int32
+}
+
+// IMUTemperature represents an IMU which also reports its
+// temperature such as the MPU6050.
+type IMUTemperature interface {
+	IMU
+	Thermometer
+}
@@ -4,11 +4,18 @@ import (
 	"math"
 
 	"gonum.org/v1/gonum/num/quat"
+	"gonum.org/v1/gonum/spatial/r3"
 )
 
 type MadgwickFilter struct {
 	Quaternion [4]float64
 	Beta       float64
+	// Zeta is the gyroscope drift compensation gain in radians per second squared.
+	// Zero disables gyroscope bias estimation.
+	Zeta     float64
+	gyroBias [3]float64
+	ahrs     IMUHeading
+	clock    sampleClock
 }
 
 func NewMadgwickFilter(beta float64) *MadgwickFilter {
@@ -18,6 +25,125 @@ func NewMadgwickFilter(beta float64) *MadgwickFilter {
 	}
 }
 
+// NewMadgwickAHRS instances a Madgwick filter with a IMU+heading sensor.
+// Subsequent calls to Update read from IMU.
+func NewMadgwickAHRS(beta float64, imuWithMagnetometer IMUHeading) *MadgwickFilter {
+	if imuWithMagnetometer == nil {
+		panic("nil IMU in NewMadgwickAHRS")
+	}
+	mf := NewMadgwickFilter(beta)
+	mf.ahrs = imuWithMagnetometer
+	return mf
+}
+
+// Update reads the IMU+heading sensor and updates the internal quaternion.
+// It panics if the filter was not created with NewMadgwickAHRS.
+// The estimate is left unchanged if samplePeriod is not positive.
+func (mf *MadgwickFilter) Update(samplePeriod float64) {
+	if mf.ahrs == nil {
+		panic("Update called on MadgwickFilter without IMU. Use UpdateAHRS or UpdateARS")
+	}
+	mf.UpdateSample(readSample(mf.ahrs, mf.ahrs, samplePeriod))
+}
+
+// UpdateAHRS updates the internal quaternion using the MARG gradient descent
+// algorithm with accelerometer, gyroscope (radians per second) and magnetometer
+// readings. The earth frame reference flux is recomputed every update
+// so that magnetic inclination does not affect roll and pitch.
+// If the magnetometer reading is zero UpdateARS is used instead.
+func (mf *MadgwickFilter) UpdateAHRS(ax, ay, az, gx, gy, gz, mx, my, mz, samplePeriod float64) {
+	if mx == 0 && my == 0 && mz == 0 {
+		mf.UpdateARS(ax, ay, az, gx, gy, gz, samplePeriod)
+		return
+	}
+	q1, q2, q3, q4 := mf.Quaternion[0], mf.Quaternion[1], mf.Quaternion[2], mf.Quaternion[3]
+	var norm, s1, s2, s3, s4, qDot1, qDot2, qDot3, qDot4 float64
+
+	norm = math.Sqrt(ax*ax + ay*ay + az*az)
+	if norm == 0 {
+		return
+	}
+	norm = 1 / norm
+	ax *= norm
+	ay *= norm
+	az *= norm
+
+	norm = 1 / math.Sqrt(mx*mx+my*my+mz*mz)
+	mx *= norm
+	my *= norm
+	mz *= norm
+
+	_2q1mx := 2 * q1 * mx
+	_2q1my := 2 * q1 * my
+	_2q1mz := 2 * q1 * mz
+	_2q2mx := 2 * q2 * mx
+	_2q1 := 2 * q1
+	_2q2 := 2 * q2
+	_2q3 := 2 * q3
+	_2q4 := 2 * q4
+	_2q1q3 := 2 * q1 * q3
+	_2q3q4 := 2 * q3 * q4
+	q1q1 := q1 * q1
+	q1q2 := q1 * q2
+	q1q3 := q1 * q3
+	q1q4 := q1 * q4
+	q2q2 := q2 * q2
+	q2q3 := q2 * q3
+	q2q4 := q2 * q4
+	q3q3 := q3 * q3
+	q3q4 := q3 * q4
+	q4q4 := q4 * q4
+
+	// Reference direction of Earth's magnetic field
+	hx := mx*q1q1 - _2q1my*q4 + _2q1mz*q3 + mx*q2q2 + _2q2*my*q3 + _2q2*mz*q4 - mx*q3q3 - mx*q4q4
+	hy := _2q1mx*q4 + my*q1q1 - _2q1mz*q2 + _2q2mx*q3 - my*q2q2 + my*q3q3 + _2q3*mz*q4 - my*q4q4
+	_2bx := math.Sqrt(hx*hx + hy*hy)
+	_2bz := -_2q1mx*q3 + _2q1my*q2 + mz*q1q1 + _2q2mx*q4 - mz*q2q2 + _2q3*my*q4 - mz*q3q3 + mz*q4q4
+	_4bx := 2 * _2bx
+	_4bz := 2 * _2bz
+
+	// Objective function errors.
+	fgx := 2*q2q4 - _2q1q3 - ax
+	fgy := 2*q1q2 + _2q3q4 - ay
+	fgz := 1 - 2*q2q2 - 2*q3q3 - az
+	fbx := _2bx*(0.5-q3q3-q4q4) + _2bz*(q2q4-q1q3) - mx
+	fby := _2bx*(q2q3-q1q4) + _2bz*(q1q2+q3q4) - my
+	fbz := _2bx*(q1q3+q2q4) + _2bz*(0.5-q2q2-q3q3) - mz
+
+	// Gradient descent corrective step
+	s1 = -_2q3*fgx + _2q2*fgy - _2bz*q3*fbx + (-_2bx*q4+_2bz*q2)*fby + _2bx*q3*fbz
+	s2 = _2q4*fgx + _2q1*fgy - 4*q2*fgz + _2bz*q4*fbx + (_2bx*q3+_2bz*q1)*fby + (_2bx*q4-_4bz*q2)*fbz
+	s3 = -_2q1*fgx + _2q4*fgy - 4*q3*fgz + (-_4bx*q3-_2bz*q1)*fbx + (_2bx*q2+_2bz*q4)*fby + (_2bx*q1-_4bz*q3)*fbz
+	s4 = _2q2*fgx + _2q3*fgy + (-_4bx*q4+_2bz*q2)*fbx + (-_2bx*q1+_2bz*q3)*fby + _2bx*q2*fbz
+
+	norm = math.Sqrt(s1*s1 + s2*s2 + s3*s3 + s4*s4)
+	if norm != 0 {
+		norm = 1 / norm
+		s1 *= norm
+		s2 *= norm
+		s3 *= norm
+		s4 *= norm
+	}
+
+	gx, gy, gz = mf.compensateDrift(s1, s2, s3, s4, gx, gy, gz, samplePeriod)
+
+	qDot1 = 0.5*(-q2*gx-q3*gy-q4*gz) - mf.Beta*s1
+	qDot2 = 0.5*(q1*gx+q3*gz-q4*gy) - mf.Beta*s2
+	qDot3 = 0.5*(q1*gy-q2*gz+q4*gx) - mf.Beta*s3
+	qDot4 = 0.5*(q1*gz+q2*gy-q3*gx) - mf.Beta*s4
+
+	q1 += qDot1 * samplePeriod
+	q2 += qDot2 * samplePeriod
+	q3 += qDot3 * samplePeriod
+	q4 += qDot4 * samplePeriod
+
+	norm = 1 / math.Sqrt(q1*q1+q2*q2+q3*q3+q4*q4)
+	mf.Quaternion[0] = q1 * norm
+	mf.Quaternion[1] = q2 * norm
+	mf.Quaternion[2] = q3 * norm
+	mf.Quaternion[3] = q4 * norm
+}
+
 func (mf *MadgwickFilter) UpdateARS(ax, ay, az, gx, gy, gz, samplePeriod float64) {
 	q1, q2, q3, q4 := mf.Quaternion[0], mf.Quaternion[1], mf.Quaternion[2], mf.Quaternion[3]
 	var norm, s1, s2, s3, s4, qDot1, qDot2, qDot3, qDot4 float64
@@ -50,11 +176,17 @@ func (mf *MadgwickFilter) UpdateARS(ax, ay, az, gx, gy, gz, samplePeriod float64
 	s3 = 4*q1q1*q3 + _2q1*ax + _4q3*q4q4 - _2q4*ay - _4q3 + _8q3*q2q2 + _8q3*q3q3 + _4q3*az
 	s4 = 4*q2q2*q4 - _2q2*ax + 4*q3q3*q4 - _2q3*ay
 
-	norm = 1 / math.Sqrt(s1*s1+s2*s2+s3*s3+s4*s4)
-	s1 *= norm
-	s2 *= norm
-	s3 *= norm
-	s4 *= norm
+	// The gradient is zero at an exactly converged attitude.
+	norm = math.Sqrt(s1*s1 + s2*s2 + s3*s3 + s4*s4)
+	if norm != 0 {
+		norm = 1 / norm
+		s1 *= norm
+		s2 *= norm
+		s3 *= norm
+		s4 *= norm
+	}
+
+	gx, gy, gz = mf.compensateDrift(s1, s2, s3, s4, gx, gy, gz, samplePeriod)
 
 	qDot1 = 0.5*(-q2*gx-q3*gy-q4*gz) - mf.Beta*s1
 	qDot2 = 0.5*(q1*gx+q3*gz-q4*gy) - mf.Beta*s2
@@ -73,6 +205,29 @@ func (mf *MadgwickFilter) UpdateARS(ax, ay, az, gx, gy, gz, samplePeriod float64
 	mf.Quaternion[3] = q4 * norm
 }
 
+// compensateDrift estimates the gyroscope bias from the normalized gradient
+// descent step s and returns the bias compensated gyroscope readings.
+func (mf *MadgwickFilter) compensateDrift(s1, s2, s3, s4, gx, gy, gz, samplePeriod float64) (float64, float64, float64) {
+	if mf.Zeta == 0 {
+		return gx - mf.gyroBias[0], gy - mf.gyroBias[1], gz - mf.gyroBias[2]
+	}
+	q1, q2, q3, q4 := mf.Quaternion[0], mf.Quaternion[1], mf.Quaternion[2], mf.Quaternion[3]
+	// Angular direction of gyroscope error, 2*conj(q)*s.
+	errx := 2 * (q1*s2 - q2*s1 - q3*s4 + q4*s3)
+	erry := 2 * (q1*s3 + q2*s4 - q3*s1 - q4*s2)
+	errz := 2 * (q1*s4 - q2*s3 + q3*s2 - q4*s1)
+	mf.gyroBias[0] += errx * samplePeriod * mf.Zeta
+	mf.gyroBias[1] += erry * samplePeriod * mf.Zeta
+	mf.gyroBias[2] += errz * samplePeriod * mf.Zeta
+	return gx - mf.gyroBias[0], gy - mf.gyroBias[1], gz - mf.gyroBias[2]
+}
+
+// GyroBias returns the estimated gyroscope bias in radians per second.
+// The bias is only estimated when Zeta is non-zero.
+func (mf *MadgwickFilter) GyroBias() r3.Vec {
+	return r3.Vec{X: mf.gyroBias[0], Y: mf.gyroBias[1], Z: mf.gyroBias[2]}
+}
+
 func (mf *MadgwickFilter) GetQuaternion() quat.Number {
 	return quat.Number{
 		Real: mf.Quaternion[0],
@@ -0,0 +1,60 @@
+package ahrs
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMadgwickAHRSHeading(t *testing.T) {
+	const (
+		dt  = 0.01
+		tol = 1e-3
+	)
+	mf := NewMadgwickFilter(0.5)
+	// Start facing west.
+	mf.Quaternion = [4]float64{math.Sqrt2 / 2, 0, 0, math.Sqrt2 / 2}
+	// Level sensor facing north in NWU.
+	for i := 0; i < 2000; i++ {
+		mf.UpdateAHRS(0, 0, 1, 0, 0, 0, 0.4, 0, -0.9, dt)
+	}
+	q := mf.GetQuaternion()
+	if !(math.Abs(math.Abs(q.Real)-1) <= tol) {
+		t.Errorf("expected identity attitude, got %v", q)
+	}
+}
+
+func TestMadgwickGyroBias(t *testing.T) {
+	const (
+		dt   = 0.01
+		bias = 0.01 // rad/s
+		tol  = 1e-3
+	)
+	mf := NewMadgwickFilter(0.1)
+	mf.Zeta = 0.05
+	for i := 0; i < 100_000; i++ {
+		mf.UpdateARS(0, 0, 1, bias, -bias, 0, dt)
+	}
+	got := mf.GyroBias()
+	if !(math.Abs(got.X-bias) <= tol && math.Abs(got.Y+bias) <= tol) {
+		t.Errorf("expected bias (%g,%g), got %+v", bias, -bias, got)
+	}
+	q := mf.GetQuaternion()
+	if !(math.Abs(math.Abs(q.Real)-1) <= tol) {
+		t.Errorf("expected identity attitude, got %v", q)
+	}
+}
+
+func TestMadgwickConverged(t *testing.T) {
+	// Gradient is exactly zero when starting from the true attitude.
+	mf := NewMadgwickFilter(0.1)
+	mf.Zeta = 0.05
+	mf.UpdateARS(0, 0, 1, 0, 0, 0, 0.01)
+	mf.UpdateAHRS(0, 0, 1, 0, 0, 0, 0.4, 0, -0.9, 0.01)
+	q := mf.GetQuaternion()
+	if !(q.Real == 1 && q.Imag == 0 && q.Jmag == 0 && q.Kmag == 0) {
+		t.Errorf("expected identity attitude, got %v", q)
+	}
+	if bias := mf.GyroBias(); bias.X != 0 || bias.Y != 0 || bias.Z != 0 {
+		t.Errorf("expected zero gyro bias, got %v", bias)
+	}
+}
@@ -0,0 +1,169 @@
+package ahrs
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/num/quat"
+)
+
+// MahonyFilter is a nonlinear complementary filter which corrects the gyroscope
+// rate with a proportional-integral feedback of the error between measured
+// and estimated reference directions (gravity and magnetic field).
+// The integral term estimates gyroscope bias.
+type MahonyFilter struct {
+	Quaternion [4]float64
+	// Kp is the proportional gain.
+	Kp float64
+	// Ki is the integral gain. Set to zero to disable integral feedback.
+	Ki float64
+	// IntegralLimit bounds the magnitude of each integral feedback component
+	// (anti-windup) in radians per second. Zero or negative disables the limit.
+	IntegralLimit float64
+	// integral feedback terms.
+	integralFB [3]float64
+	clock      sampleClock
+}
+
+// NewMahonyFilter returns a MahonyFilter with identity attitude. A
+// reasonable starting point is kp=1, ki=0.
+func NewMahonyFilter(kp, ki float64) *MahonyFilter {
+	return &MahonyFilter{
+		Quaternion: [4]float64{1, 0, 0, 0},
+		Kp:         kp,
+		Ki:         ki,
+	}
+}
+
+// UpdateARS updates the attitude estimate with accelerometer and gyroscope
+// readings. Gyroscope readings are in radians per second and samplePeriod in seconds.
+func (mf *MahonyFilter) UpdateARS(ax, ay, az, gx, gy, gz, samplePeriod float64) {
+	q1, q2, q3, q4 := mf.Quaternion[0], mf.Quaternion[1], mf.Quaternion[2], mf.Quaternion[3]
+
+	norm := math.Sqrt(ax*ax + ay*ay + az*az)
+	if norm != 0 {
+		norm = 1 / norm
+		ax *= norm
+		ay *= norm
+		az *= norm
+
+		// Estimated direction of gravity (half).
+		halfvx := q2*q4 - q1*q3
+		halfvy := q1*q2 + q3*q4
+		halfvz := q1*q1 - 0.5 + q4*q4
+
+		// Error is cross product between estimated and measured direction of gravity.
+		halfex := ay*halfvz - az*halfvy
+		halfey := az*halfvx - ax*halfvz
+		halfez := ax*halfvy - ay*halfvx
+		gx, gy, gz = mf.feedback(halfex, halfey, halfez, gx, gy, gz, samplePeriod)
+	}
+	mf.integrate(gx, gy, gz, samplePeriod)
+}
+
+// UpdateAHRS updates the attitude estimate with accelerometer, gyroscope and
+// magnetometer readings. Gyroscope readings are in radians per second and samplePeriod in seconds.
+// If the magnetometer reading is zero UpdateARS is used instead.
+func (mf *MahonyFilter) UpdateAHRS(ax, ay, az, gx, gy, gz, mx, my, mz, samplePeriod float64) {
+	if mx == 0 && my == 0 && mz == 0 {
+		mf.UpdateARS(ax, ay, az, gx, gy, gz, samplePeriod)
+		return
+	}
+	q1, q2, q3, q4 := mf.Quaternion[0], mf.Quaternion[1], mf.Quaternion[2], mf.Quaternion[3]
+
+	norm := math.Sqrt(ax*ax + ay*ay + az*az)
+	if norm != 0 {
+		norm = 1 / norm
+		ax *= norm
+		ay *= norm
+		az *= norm
+
+		norm = 1 / math.Sqrt(mx*mx+my*my+mz*mz)
+		mx *= norm
+		my *= norm
+		mz *= norm
+
+		q1q1 := q1 * q1
+		q1q2 := q1 * q2
+		q1q3 := q1 * q3
+		q1q4 := q1 * q4
+		q2q2 := q2 * q2
+		q2q3 := q2 * q3
+		q2q4 := q2 * q4
+		q3q3 := q3 * q3
+		q3q4 := q3 * q4
+		q4q4 := q4 * q4
+
+		// Reference direction of Earth's magnetic field.
+		hx := 2 * (mx*(0.5-q3q3-q4q4) + my*(q2q3-q1q4) + mz*(q2q4+q1q3))
+		hy := 2 * (mx*(q2q3+q1q4) + my*(0.5-q2q2-q4q4) + mz*(q3q4-q1q2))
+		bx := math.Sqrt(hx*hx + hy*hy)
+		bz := 2 * (mx*(q2q4-q1q3) + my*(q3q4+q1q2) + mz*(0.5-q2q2-q3q3))
+
+		// Estimated direction of gravity and magnetic field (half).
+		halfvx := q2q4 - q1q3
+		halfvy := q1q2 + q3q4
+		halfvz := q1q1 - 0.5 + q4q4
+		halfwx := bx*(0.5-q3q3-q4q4) + bz*(q2q4-q1q3)
+		halfwy := bx*(q2q3-q1q4) + bz*(q1q2+q3q4)
+		halfwz := bx*(q1q3+q2q4) + bz*(0.5-q2q2-q3q3)
+
+		// Error is sum of cross product between estimated and measured direction of fields.
+		halfex := (ay*halfvz - az*halfvy) + (my*halfwz - mz*halfwy)
+		halfey := (az*halfvx - ax*halfvz) + (mz*halfwx - mx*halfwz)
+		halfez := (ax*halfvy - ay*halfvx) + (mx*halfwy - my*halfwx)
+		gx, gy, gz = mf.feedback(halfex, halfey, halfez, gx, gy, gz, samplePeriod)
+	}
+	mf.integrate(gx, gy, gz, samplePeriod)
+}
+
+// feedback applies proportional and integral feedback of the half error to the gyroscope readings.
+func (mf *MahonyFilter) feedback(halfex, halfey, halfez, gx, gy, gz, samplePeriod float64) (float64, float64, float64) {
+	if mf.Ki > 0 {
+		mf.integralFB[0] += 2 * mf.Ki * halfex * samplePeriod
+		mf.integralFB[1] += 2 * mf.Ki * halfey * samplePeriod
+		mf.integralFB[2] += 2 * mf.Ki * halfez * samplePeriod
+		if mf.IntegralLimit > 0 {
+			for i := range mf.integralFB {
+				mf.integralFB[i] = clamp(mf.integralFB[i], -mf.IntegralLimit, mf.IntegralLimit)
+			}
+		}
+		gx += mf.integralFB[0]
+		gy += mf.integralFB[1]
+		gz += mf.integralFB[2]
+	} else {
+		// Prevent integral windup when integral feedback is disabled.
+		mf.integralFB = [3]float64{}
+	}
+	gx += 2 * mf.Kp * halfex
+	gy += 2 * mf.Kp * halfey
+	gz += 2 * mf.Kp * halfez
+	return gx, gy, gz
+}
+
+// integrate integrates the rate of change of the quaternion.
+func (mf *MahonyFilter) integrate(gx, gy, gz, samplePeriod float64) {
+	q1, q2, q3, q4 := mf.Quaternion[0], mf.Quaternion[1], mf.Quaternion[2], mf.Quaternion[3]
+	gx *= 0.5 * samplePeriod
+	gy *= 0.5 * samplePeriod
+	gz *= 0.5 * samplePeriod
+	qa, qb, qc := q1, q2, q3
+	q1 += -qb*gx - qc*gy - q4*gz
+	q2 += qa*gx + qc*gz - q4*gy
+	q3 += qa*gy - qb*gz + q4*gx
+	q4 += qa*gz + qb*gy - qc*gx
+
+	norm := 1 / math.Sqrt(q1*q1+q2*q2+q3*q3+q4*q4)
+	mf.Quaternion[0] = q1 * norm
+	mf.Quaternion[1] = q2 * norm
+	mf.Quaternion[2] = q3 * norm
+	mf.Quaternion[3] = q4 * norm
+}
+
+func (mf *MahonyFilter) GetQuaternion() quat.Number {
+	return quat.Number{
+		Real: mf.Quaternion[0],
+		Imag: mf.Quaternion[1],
+		Jmag: mf.Quaternion[2],
+		Kmag: mf.Quaternion[3],
+	}
+}
@@ -0,0 +1,33 @@
+package ahrs
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMahonyGyroBias(t *testing.T) {
+	const (
+		dt   = 0.01
+		bias = 0.02 // rad/s
+		tol  = 1e-3
+	)
+	mf := NewMahonyFilter(2, 0.5)
+	mf.IntegralLimit = 0.1
+	// Level sensor facing north in NWU with a constant gyro bias.
+	for i := 0; i < 100_000; i++ {
+		mf.UpdateAHRS(0, 0, 1, bias, -bias, bias, 0.4, 0, -0.9, dt)
+	}
+	for i, fb := range mf.integralFB {
+		expect := -bias
+		if i == 1 {
+			expect = bias
+		}
+		if math.Abs(fb-expect) > tol {
+			t.Errorf("integral feedback %d: expected %g, got %g", i, expect, fb)
+		}
+	}
+	q := mf.GetQuaternion()
+	if math.Abs(math.Abs(q.Real)-1) > tol {
+		t.Errorf("expected identity attitude, got %v", q)
+	}
+}
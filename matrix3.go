@@ -0,0 +1,69 @@
+package ahrs
+
+import (
+	"errors"
+
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// Matrix3 is a 3x3 matrix stored in row major order. Unlike
+// RotationMatrix it may represent any linear transformation
+// such as scaling and misalignment corrections.
+type Matrix3 [3][3]float64
+
+// Identity3 returns the 3x3 identity matrix.
+func Identity3() Matrix3 {
+	return Matrix3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+}
+
+// MulVec returns m*v.
+func (m Matrix3) MulVec(v r3.Vec) r3.Vec {
+	return r3.Vec{
+		X: m[0][0]*v.X + m[0][1]*v.Y + m[0][2]*v.Z,
+		Y: m[1][0]*v.X + m[1][1]*v.Y + m[1][2]*v.Z,
+		Z: m[2][0]*v.X + m[2][1]*v.Y + m[2][2]*v.Z,
+	}
+}
+
+// Mul returns m*b.
+func (m Matrix3) Mul(b Matrix3) (result Matrix3) {
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			result[i][j] = m[i][0]*b[0][j] + m[i][1]*b[1][j] + m[i][2]*b[2][j]
+		}
+	}
+	return result
+}
+
+// Det returns the determinant of m.
+func (m Matrix3) Det() float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+// Inverse returns the inverse of m. It returns an error if m is singular.
+func (m Matrix3) Inverse() (Matrix3, error) {
+	det := m.Det()
+	if det == 0 {
+		return Matrix3{}, errors.New("singular matrix")
+	}
+	inv := 1 / det
+	return Matrix3{
+		{
+			inv * (m[1][1]*m[2][2] - m[1][2]*m[2][1]),
+			inv * (m[0][2]*m[2][1] - m[0][1]*m[2][2]),
+			inv * (m[0][1]*m[1][2] - m[0][2]*m[1][1]),
+		},
+		{
+			inv * (m[1][2]*m[2][0] - m[1][0]*m[2][2]),
+			inv * (m[0][0]*m[2][2] - m[0][2]*m[2][0]),
+			inv * (m[0][2]*m[1][0] - m[0][0]*m[1][2]),
+		},
+		{
+			inv * (m[1][0]*m[2][1] - m[1][1]*m[2][0]),
+			inv * (m[0][1]*m[2][0] - m[0][0]*m[2][1]),
+			inv * (m[0][0]*m[1][1] - m[0][1]*m[1][0]),
+		},
+	}, nil
+}
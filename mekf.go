@@ -0,0 +1,210 @@
+package ahrs
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/num/quat"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+const (
+	// Initial standard deviation of attitude error in radians.
+	mekfInitialAttitudeStd = 1.0
+	// Initial standard deviation of gyroscope bias in radians per second.
+	mekfInitialBiasStd = 0.1
+)
+
+// MEKFNoise holds the noise parameters of a MEKF.
+type MEKFNoise struct {
+	// GyroNoise is the gyroscope noise density (angle random walk) in rad/s/√Hz.
+	GyroNoise float64
+	// GyroBiasRandomWalk is the gyroscope bias random walk in rad/s²/√Hz.
+	GyroBiasRandomWalk float64
+	// AccelNoise is the standard deviation of the normalized accelerometer
+	// measurement. Larger values trust the accelerometer less during dynamic motion.
+	AccelNoise float64
+	// MagNoise is the standard deviation of the normalized magnetometer measurement.
+	MagNoise float64
+}
+
+// MEKF is a multiplicative extended Kalman filter which estimates attitude
+// and gyroscope bias. The error state is the 3 component attitude error
+// in the body frame followed by the 3 component gyroscope bias error.
+type MEKF struct {
+	attitude quat.Number
+	bias     r3.Vec
+	// error state covariance.
+	p     *mat.Dense
+	noise MEKFNoise
+	clock sampleClock
+}
+
+// NewMEKF returns a MEKF with identity attitude and zero gyroscope bias.
+func NewMEKF(noise MEKFNoise) *MEKF {
+	k := &MEKF{noise: noise}
+	k.reset()
+	return k
+}
+
+func (k *MEKF) reset() {
+	k.attitude = quatIdentity
+	k.bias = r3.Vec{}
+	k.clock = sampleClock{}
+	k.p = mat.NewDense(6, 6, nil)
+	for i := 0; i < 3; i++ {
+		k.p.Set(i, i, mekfInitialAttitudeStd*mekfInitialAttitudeStd)
+		k.p.Set(i+3, i+3, mekfInitialBiasStd*mekfInitialBiasStd)
+	}
+}
+
+// UpdateARS propagates the filter with gyroscope readings (radians per second)
+// and corrects it with accelerometer readings. samplePeriod is in seconds.
+func (k *MEKF) UpdateARS(ax, ay, az, gx, gy, gz, samplePeriod float64) {
+	k.predict(r3.Vec{X: gx, Y: gy, Z: gz}, samplePeriod)
+	k.correctAccel(r3.Vec{X: ax, Y: ay, Z: az})
+}
+
+// UpdateAHRS propagates the filter with gyroscope readings (radians per second)
+// and corrects it with accelerometer and magnetometer readings. samplePeriod is in seconds.
+// The magnetometer only corrects heading.
+func (k *MEKF) UpdateAHRS(ax, ay, az, gx, gy, gz, mx, my, mz, samplePeriod float64) {
+	k.predict(r3.Vec{X: gx, Y: gy, Z: gz}, samplePeriod)
+	k.correctAccel(r3.Vec{X: ax, Y: ay, Z: az})
+	k.correctMag(r3.Vec{X: mx, Y: my, Z: mz})
+}
+
+func (k *MEKF) predict(gyro r3.Vec, dt float64) {
+	w := r3.Sub(gyro, k.bias)
+	// Integrate attitude with exact quaternion exponential.
+	angle := r3.Norm(w) * dt
+	if angle > 0 {
+		axis := r3.Unit(w)
+		s := math.Sin(angle / 2)
+		dq := quat.Number{Real: math.Cos(angle / 2), Imag: s * axis.X, Jmag: s * axis.Y, Kmag: s * axis.Z}
+		k.attitude = NormalizeQuaternion(quat.Mul(k.attitude, dq))
+	}
+
+	// First order discrete error state transition.
+	phi := mat.NewDense(6, 6, nil)
+	for i := 0; i < 6; i++ {
+		phi.Set(i, i, 1)
+	}
+	wx := skew(w)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			phi.Set(i, j, phi.At(i, j)-wx[i][j]*dt)
+		}
+		phi.Set(i, i+3, -dt)
+	}
+	var p mat.Dense
+	p.Product(phi, k.p, phi.T())
+	gq := k.noise.GyroNoise * k.noise.GyroNoise * dt
+	bq := k.noise.GyroBiasRandomWalk * k.noise.GyroBiasRandomWalk * dt
+	for i := 0; i < 3; i++ {
+		p.Set(i, i, p.At(i, i)+gq)
+		p.Set(i+3, i+3, p.At(i+3, i+3)+bq)
+	}
+	k.p = &p
+}
+
+func (k *MEKF) correctAccel(accel r3.Vec) {
+	if accel.X == 0 && accel.Y == 0 && accel.Z == 0 {
+		return
+	}
+	up := rotateVecInv(k.attitude, r3.Vec{Z: 1})
+	k.correct(r3.Unit(accel), up, k.noise.AccelNoise)
+}
+
+func (k *MEKF) correctMag(magnet r3.Vec) {
+	if magnet.X == 0 && magnet.Y == 0 && magnet.Z == 0 {
+		return
+	}
+	magnet = r3.Unit(magnet)
+	// Recompute earth frame reference flux so that inclination
+	// does not affect roll and pitch.
+	earth := rotateVec(k.attitude, magnet)
+	ref := r3.Vec{X: math.Hypot(earth.X, earth.Y), Z: earth.Z}
+	k.correct(magnet, rotateVecInv(k.attitude, ref), k.noise.MagNoise)
+}
+
+// correct applies a vector measurement z with expected body frame value h.
+func (k *MEKF) correct(z, h r3.Vec, std float64) {
+	hx := skew(h)
+	H := mat.NewDense(3, 6, nil)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			H.Set(i, j, hx[i][j])
+		}
+	}
+	var s mat.Dense
+	s.Product(H, k.p, H.T())
+	for i := 0; i < 3; i++ {
+		s.Set(i, i, s.At(i, i)+std*std)
+	}
+	var sinv mat.Dense
+	if err := sinv.Inverse(&s); err != nil {
+		return // Singular innovation covariance, skip measurement.
+	}
+	var gain mat.Dense
+	gain.Product(k.p, H.T(), &sinv)
+
+	y := mat.NewVecDense(3, []float64{z.X - h.X, z.Y - h.Y, z.Z - h.Z})
+	var dx mat.VecDense
+	dx.MulVec(&gain, y)
+
+	// Apply error state to attitude and bias.
+	dq := quat.Number{Real: 1, Imag: dx.AtVec(0) / 2, Jmag: dx.AtVec(1) / 2, Kmag: dx.AtVec(2) / 2}
+	k.attitude = NormalizeQuaternion(quat.Mul(k.attitude, dq))
+	k.bias = r3.Add(k.bias, r3.Vec{X: dx.AtVec(3), Y: dx.AtVec(4), Z: dx.AtVec(5)})
+
+	// Joseph form covariance update.
+	var ikh mat.Dense
+	ikh.Mul(&gain, H)
+	ikh.Scale(-1, &ikh)
+	for i := 0; i < 6; i++ {
+		ikh.Set(i, i, ikh.At(i, i)+1)
+	}
+	var p, krk mat.Dense
+	p.Product(&ikh, k.p, ikh.T())
+	krk.Mul(&gain, gain.T())
+	krk.Scale(std*std, &krk)
+	p.Add(&p, &krk)
+	// Enforce symmetry.
+	var pt mat.Dense
+	pt.CloneFrom(p.T())
+	p.Add(&p, &pt)
+	p.Scale(0.5, &p)
+	k.p = &p
+}
+
+// GetQuaternion returns the attitude estimate.
+func (k *MEKF) GetQuaternion() quat.Number {
+	return k.attitude
+}
+
+// GyroBias returns the estimated gyroscope bias in radians per second.
+func (k *MEKF) GyroBias() r3.Vec {
+	return k.bias
+}
+
+// Covariance returns a copy of the 6x6 error state covariance. The first three
+// rows correspond to attitude error in radians and the last three to gyroscope bias.
+func (k *MEKF) Covariance() *mat.SymDense {
+	cov := mat.NewSymDense(6, nil)
+	for i := 0; i < 6; i++ {
+		for j := i; j < 6; j++ {
+			cov.SetSym(i, j, k.p.At(i, j))
+		}
+	}
+	return cov
+}
+
+// skew returns the cross product matrix of v such that skew(v)*u = v x u.
+func skew(v r3.Vec) [3][3]float64 {
+	return [3][3]float64{
+		{0, -v.Z, v.Y},
+		{v.Z, 0, -v.X},
+		{-v.Y, v.X, 0},
+	}
+}
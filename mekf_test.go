@@ -0,0 +1,38 @@
+package ahrs
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMEKFGyroBias(t *testing.T) {
+	const (
+		dt   = 0.01
+		bias = 0.02 // rad/s
+		tol  = 1e-3
+	)
+	k := NewMEKF(MEKFNoise{
+		GyroNoise:          1e-3,
+		GyroBiasRandomWalk: 1e-5,
+		AccelNoise:         0.05,
+		MagNoise:           0.1,
+	})
+	initialCov := k.Covariance()
+	for i := 0; i < 20_000; i++ {
+		k.UpdateAHRS(0, 0, 1, bias, -bias, bias, 0.4, 0, -0.9, dt)
+	}
+	got := k.GyroBias()
+	if math.Abs(got.X-bias) > tol || math.Abs(got.Y+bias) > tol || math.Abs(got.Z-bias) > tol {
+		t.Errorf("expected bias (%g,%g,%g), got %+v", bias, -bias, bias, got)
+	}
+	q := k.GetQuaternion()
+	if math.Abs(math.Abs(q.Real)-1) > tol {
+		t.Errorf("expected identity attitude, got %v", q)
+	}
+	cov := k.Covariance()
+	for i := 0; i < 6; i++ {
+		if cov.At(i, i) >= initialCov.At(i, i) {
+			t.Errorf("expected covariance %d to decrease from %g, got %g", i, initialCov.At(i, i), cov.At(i, i))
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package ahrs
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+const (
+	// Cutoff frequency of gyroscope offset low pass filter in Hz.
+	offsetCutoffFrequency = 0.02
+	// Time in seconds the gyroscope must be still before the offset is updated.
+	offsetTimeout = 5
+	// Default angular velocity below which every gyroscope axis is considered still (3 deg/s).
+	offsetThreshold = 3 * math.Pi / 180
+)
+
+// GyroOffset estimates the gyroscope offset at runtime. When the gyroscope
+// has been stationary for a few seconds the offset is slowly adjusted with a
+// low pass filter. Modelled after FusionOffset in xioTechnologies/Fusion.
+//
+// GyroOffset implements IMU so it can be placed between a sensor driver and
+// an estimator:
+//
+//	offset := ahrs.NewGyroOffset(sensor, sampleRate)
+//	estimator := ahrs.NewXioAHRS(0.5, ahrs.NewIMUHeading(offset, sensor))
+type GyroOffset struct {
+	imu IMU
+	// Threshold is the angular velocity in radians per second every axis
+	// must be below for the gyroscope to be considered stationary.
+	Threshold         float64
+	filterCoefficient float64
+	timeout, timer    int
+	offset            r3.Vec
+}
+
+// NewGyroOffset returns a gyroscope offset estimator for readings sampled at
+// sampleRate in Hz. imu may be nil if readings are fed through Update.
+func NewGyroOffset(imu IMU, sampleRate float64) *GyroOffset {
+	if !(sampleRate > 0) {
+		panic("sample rate must be positive in NewGyroOffset")
+	}
+	return &GyroOffset{
+		imu:               imu,
+		Threshold:         offsetThreshold,
+		filterCoefficient: 2 * math.Pi * offsetCutoffFrequency / sampleRate,
+		timeout:           int(offsetTimeout * sampleRate),
+	}
+}
+
+// Update subtracts the offset estimate from the gyroscope reading (radians per second)
+// and updates the estimate if the gyroscope is stationary. It must be called
+// once per sample.
+func (o *GyroOffset) Update(gyro r3.Vec) r3.Vec {
+	gyro = r3.Sub(gyro, o.offset)
+	// Reset timer if gyroscope not stationary.
+	if math.Abs(gyro.X) > o.Threshold || math.Abs(gyro.Y) > o.Threshold || math.Abs(gyro.Z) > o.Threshold {
+		o.timer = 0
+		return gyro
+	}
+	// Increment timer while gyroscope stationary.
+	if o.timer < o.timeout {
+		o.timer++
+		return gyro
+	}
+	// Adjust offset once timer has elapsed.
+	o.offset = r3.Add(o.offset, r3.Scale(o.filterCoefficient, gyro))
+	return gyro
+}
+
+// Offset returns the current gyroscope offset estimate in radians per second.
+func (o *GyroOffset) Offset() r3.Vec { return o.offset }
+
+// Acceleration returns the accelerometer readings of the underlying IMU.
+func (o *GyroOffset) Acceleration() (ax, ay, az int32) {
+	return o.imu.Acceleration()
+}
+
+// AngularVelocity reads the underlying IMU and returns the offset
+// corrected angular velocity in micro radians per second. Every call is
+// fed to Update so it advances the stationary timer and may adjust the
+// offset; it must be called once per sample.
+func (o *GyroOffset) AngularVelocity() (gx, gy, gz int32) {
+	gx, gy, gz = o.imu.AngularVelocity()
+	gyro := o.Update(scaledVecFromInt(1e-6, gx, gy, gz))
+	return int32(math.Round(gyro.X * 1e6)), int32(math.Round(gyro.Y * 1e6)), int32(math.Round(gyro.Z * 1e6))
+}
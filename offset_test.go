@@ -0,0 +1,50 @@
+package ahrs
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGyroOffset(t *testing.T) {
+	const (
+		sampleRate = 100
+		tol        = 1e-3
+	)
+	// Still sensor with gyroscope bias of 0.5 deg/s on each axis.
+	bias := int32(8727) // micro radians per second
+	imu := &testIMU{accel: [3]int32{0, 0, 1e6}, gyro: [3]int32{bias, -bias, bias}}
+	offset := NewGyroOffset(imu, sampleRate)
+	for i := 0; i < 120*sampleRate; i++ {
+		offset.AngularVelocity()
+	}
+	gx, gy, gz := offset.AngularVelocity()
+	for _, g := range []int32{gx, gy, gz} {
+		if math.Abs(float64(g)*1e-6) > tol {
+			t.Errorf("expected offset corrected gyroscope near zero, got %d %d %d", gx, gy, gz)
+			break
+		}
+	}
+	// Moving sensor must not update offset.
+	before := offset.Offset()
+	imu.gyro = [3]int32{1e6, 0, 0}
+	for i := 0; i < 10*sampleRate; i++ {
+		offset.AngularVelocity()
+	}
+	if offset.Offset() != before {
+		t.Errorf("offset updated while moving: %+v to %+v", before, offset.Offset())
+	}
+}
+
+func TestGyroOffsetPerAxisThreshold(t *testing.T) {
+	const sampleRate = 100
+	// Every axis below the 3 deg/s threshold although the magnitude exceeds it.
+	const rate = 43633 // 2.5 deg/s in micro radians per second.
+	imu := &testIMU{gyro: [3]int32{rate, rate, -rate}}
+	offset := NewGyroOffset(imu, sampleRate)
+	for i := 0; i < 10*sampleRate; i++ {
+		offset.AngularVelocity()
+	}
+	if offset.Offset().X <= 0 {
+		t.Errorf("expected offset update while every axis is below threshold, got %+v", offset.Offset())
+	}
+}
@@ -21,7 +21,7 @@ func RotationMatrixFromQuat(q quat.Number) (r RotationMatrix) {
 	qxqy := q.Imag * q.Jmag
 	qxqz := q.Imag * q.Kmag
 	qyqz := q.Jmag * q.Kmag
-	r.xx = 2 * (qwqw - .5*q.Imag*q.Imag)
+	r.xx = 2 * (qwqw - .5 + q.Imag*q.Imag)
 	r.xy = 2 * (qxqy + qwqz)
 	r.xz = 2.0 * (qxqz - qwqy)
 	r.yx = 2.0 * (qxqy - qwqz)
@@ -55,127 +55,229 @@ func (A *RotationMatrix) Mul(B *RotationMatrix) (result RotationMatrix) {
 }
 
 // RotationOrder represents a convention for rotating a frame.
-// This package implements intrinsic Tait-Bryan rotations at the time of writing this.
+//
+// The orders without prefix, i.e. OrderXYZ, are extrinsic by the usual definition
+// (scipy's lowercase sequences): the order ABC rotates about the fixed axes A, B then C
+// and corresponds to the attitude quaternion qC*qB*qA, where qA is the rotation about
+// axis A. This is equivalent to the intrinsic order CBA. They were named intrinsic in
+// earlier versions of this package. The OrderIntrinsic orders rotate about the axes of
+// the rotated frame (scipy's uppercase sequences): the order ABC corresponds to qA*qB*qC.
+//
+// For Tait-Bryan orders the angles Q, R and S of EulerAngles are the rotations
+// about the X, Y and Z axes respectively. For proper Euler orders the first and last
+// axes are the same so Q, R and S are the first, second and third rotation angles:
+// the extrinsic order ABA corresponds to qA(S)*qB(R)*qA(Q) and the intrinsic order
+// ABA to qA(Q)*qB(R)*qA(S).
 type RotationOrder int
 
 const (
 	orderUndefined RotationOrder = iota
-	// Tait-Bryan XYZ intrinsic rotation
+	// Tait-Bryan XYZ extrinsic rotation
 	OrderXYZ
-	// Tait-Bryan YXZ intrinsic rotation
+	// Tait-Bryan YXZ extrinsic rotation
 	OrderYXZ
-	// Tait-Bryan ZXY intrinsic rotation
+	// Tait-Bryan ZXY extrinsic rotation
 	OrderZXY
-	// Tait-Bryan ZYX intrinsic rotation
+	// Tait-Bryan ZYX extrinsic rotation
 	OrderZYX
-	// Tait-Bryan YZX intrinsic rotation
+	// Tait-Bryan YZX extrinsic rotation
 	OrderYZX
-	// Tait-Bryan XZY intrinsic rotation
+	// Tait-Bryan XZY extrinsic rotation
 	OrderXZY
+	// Proper Euler XYX extrinsic rotation
+	OrderXYX
+	// Proper Euler XZX extrinsic rotation
+	OrderXZX
+	// Proper Euler YXY extrinsic rotation
+	OrderYXY
+	// Proper Euler YZY extrinsic rotation
+	OrderYZY
+	// Proper Euler ZXZ extrinsic rotation
+	OrderZXZ
+	// Proper Euler ZYZ extrinsic rotation
+	OrderZYZ
+	// Tait-Bryan XYZ intrinsic rotation
+	OrderIntrinsicXYZ
+	// Tait-Bryan YXZ intrinsic rotation
+	OrderIntrinsicYXZ
+	// Tait-Bryan ZXY intrinsic rotation
+	OrderIntrinsicZXY
+	// Tait-Bryan ZYX intrinsic rotation
+	OrderIntrinsicZYX
+	// Tait-Bryan YZX intrinsic rotation
+	OrderIntrinsicYZX
+	// Tait-Bryan XZY intrinsic rotation
+	OrderIntrinsicXZY
+	// Proper Euler XYX intrinsic rotation
+	OrderIntrinsicXYX
+	// Proper Euler XZX intrinsic rotation
+	OrderIntrinsicXZX
+	// Proper Euler YXY intrinsic rotation
+	OrderIntrinsicYXY
+	// Proper Euler YZY intrinsic rotation
+	OrderIntrinsicYZY
+	// Proper Euler ZXZ intrinsic rotation
+	OrderIntrinsicZXZ
+	// Proper Euler ZYZ intrinsic rotation
+	OrderIntrinsicZYZ
 	orderLen
 )
 
+// rotationOrderAxes holds the axes (0=X, 1=Y, 2=Z) of each rotation order in the order they are named.
+var rotationOrderAxes = [orderLen][3]int{
+	OrderXYZ: {0, 1, 2}, OrderYXZ: {1, 0, 2}, OrderZXY: {2, 0, 1},
+	OrderZYX: {2, 1, 0}, OrderYZX: {1, 2, 0}, OrderXZY: {0, 2, 1},
+	OrderXYX: {0, 1, 0}, OrderXZX: {0, 2, 0}, OrderYXY: {1, 0, 1},
+	OrderYZY: {1, 2, 1}, OrderZXZ: {2, 0, 2}, OrderZYZ: {2, 1, 2},
+	OrderIntrinsicXYZ: {0, 1, 2}, OrderIntrinsicYXZ: {1, 0, 2}, OrderIntrinsicZXY: {2, 0, 1},
+	OrderIntrinsicZYX: {2, 1, 0}, OrderIntrinsicYZX: {1, 2, 0}, OrderIntrinsicXZY: {0, 2, 1},
+	OrderIntrinsicXYX: {0, 1, 0}, OrderIntrinsicXZX: {0, 2, 0}, OrderIntrinsicYXY: {1, 0, 1},
+	OrderIntrinsicYZY: {1, 2, 1}, OrderIntrinsicZXZ: {2, 0, 2}, OrderIntrinsicZYZ: {2, 1, 2},
+}
+
+// Intrinsic returns true if r is an intrinsic rotation order.
+func (r RotationOrder) Intrinsic() bool {
+	return r >= OrderIntrinsicXYZ && r < orderLen
+}
+
+// ProperEuler returns true if r is a proper Euler order, that is, its first
+// and last axes are the same. Orders which are not proper Euler are Tait-Bryan.
+func (r RotationOrder) ProperEuler() bool {
+	return r > orderUndefined && r < orderLen && rotationOrderAxes[r][0] == rotationOrderAxes[r][2]
+}
+
 func (r RotationOrder) String() (order string) {
-	switch r {
-	case OrderXYZ:
-		order = "XYZ"
-	case OrderYXZ:
-		order = "YXZ"
-	case OrderXZY:
-		order = "XZY"
-	case OrderYZX:
-		order = "YZX"
-	case OrderZXY:
-		order = "ZXY"
-	case OrderZYX:
-		order = "ZYX"
-	default:
-		order = "undefined rotation order"
+	if r <= orderUndefined || r >= orderLen {
+		return "undefined rotation order"
+	}
+	axes := rotationOrderAxes[r]
+	for _, axis := range axes {
+		order += string(rune('X' + axis))
+	}
+	if r.Intrinsic() {
+		order = "intrinsic " + order
 	}
 	return order
 }
 
-// Euler angle calculations from
-// https://github.com/mrdoob/three.js/blob/8ff5d832eedfd7bc698301febb60920173770899/src/math/Euler.js
-
-// TaitBryan returns intrinsic angles of a TaitBryan rotation.
-// Assumes the upper 3x3 of r is a pure rotation matrix (i.e, unscaled)
+// TaitBryan returns the angles of a Tait-Bryan rotation. It panics
+// if order is a proper Euler order. See Euler.
 func (r *RotationMatrix) TaitBryan(order RotationOrder) (taitBryanAngles EulerAngles) {
-	const lim1 = 0.9999999
-	taitBryanAngles.Order = order
-	switch order {
-
-	case OrderXYZ:
-		taitBryanAngles.R = math.Asin(clamp(r.xz, -1, 1))
-		if math.Abs(r.xz) < lim1 {
-			taitBryanAngles.Q = math.Atan2(-r.yz, r.zz)
-			taitBryanAngles.S = math.Atan2(-r.xy, r.xx)
-		} else {
-			taitBryanAngles.Q = math.Atan2(r.zy, r.yy)
-			taitBryanAngles.S = 0
-		}
+	if order.ProperEuler() {
+		panic("proper Euler rotation order passed to TaitBryan")
+	}
+	return r.Euler(order)
+}
 
-	case OrderYXZ:
-		taitBryanAngles.Q = math.Asin(-clamp(r.yz, -1, 1))
-		if math.Abs(r.yz) < lim1 {
-			taitBryanAngles.R = math.Atan2(r.xz, r.zz)
-			taitBryanAngles.S = math.Atan2(r.yx, r.yy)
-		} else {
-			taitBryanAngles.R = math.Atan2(-r.zx, r.xx)
-			taitBryanAngles.S = 0
-		}
+// Euler returns the angles of a Tait-Bryan or proper Euler rotation of the given order.
+// Assumes r is a pure rotation matrix (i.e, unscaled). At gimbal lock, when the
+// first and third axes are aligned, the angle of the leftmost rotation of the
+// attitude quaternion is zero.
+func (r *RotationMatrix) Euler(order RotationOrder) (angles EulerAngles) {
+	if order <= orderUndefined || order >= orderLen {
+		panic("undefined or unimplemented rotation order")
+	}
+	// The attitude rotation matrix is the transpose of r.
+	M := Matrix3{
+		{r.xx, r.yx, r.zx},
+		{r.xy, r.yy, r.zy},
+		{r.xz, r.yz, r.zz},
+	}
+	axes := rotationOrderAxes[order]
+	intrinsic := order.Intrinsic()
+	// Decompose as M = Ra(θ1)*Rb(θ2)*Rc(θ3).
+	a, b, c := axes[2], axes[1], axes[0]
+	if intrinsic {
+		a, c = c, a
+	}
+	theta := eulerFromMatrix(M, a, b, c)
+	if !intrinsic {
+		// Extrinsic rotation applied last is the leftmost.
+		theta[0], theta[2] = theta[2], theta[0]
+	}
+	angles.Order = order
+	if order.ProperEuler() {
+		angles.Q, angles.R, angles.S = theta[0], theta[1], theta[2]
+		return angles
+	}
+	var byAxis [3]float64
+	for i, axis := range axes {
+		byAxis[axis] = theta[i]
+	}
+	angles.Q, angles.R, angles.S = byAxis[0], byAxis[1], byAxis[2]
+	return angles
+}
 
-	case OrderZXY:
-		taitBryanAngles.Q = math.Asin(clamp(r.zy, -1, 1))
-		if math.Abs(r.zy) < lim1 {
-			taitBryanAngles.R = math.Atan2(-r.zx, r.zz)
-			taitBryanAngles.S = math.Atan2(-r.xy, r.yy)
+// eulerFromMatrix returns the angles such that M = Ri(θ1)*Rj(θ2)*Rk(θ3), where Ri
+// is a rotation about axis i. k may equal i for proper Euler sequences.
+// At gimbal lock θ1 is zero.
+func eulerFromMatrix(M Matrix3, i, j, k int) (theta [3]float64) {
+	const lim = 1e-7
+	proper := i == k
+	if proper {
+		k = 3 - i - j
+	}
+	// Sign of the permutation (i, j, k).
+	eps := 1.0
+	if (j-i+3)%3 != 1 {
+		eps = -1
+	}
+	if proper {
+		sin2 := math.Hypot(M[i][j], M[i][k])
+		theta[1] = math.Atan2(sin2, M[i][i])
+		if sin2 > lim {
+			theta[0] = math.Atan2(M[j][i], -eps*M[k][i])
+			theta[2] = math.Atan2(M[i][j], eps*M[i][k])
 		} else {
-			taitBryanAngles.R = 0
-			taitBryanAngles.S = math.Atan2(r.yx, r.xx)
+			theta[2] = math.Atan2(-eps*M[j][k], M[j][j])
 		}
+		return theta
+	}
+	theta[1] = math.Asin(clamp(eps*M[i][k], -1, 1))
+	if math.Abs(M[i][k]) < 1-lim {
+		theta[0] = math.Atan2(-eps*M[j][k], M[k][k])
+		theta[2] = math.Atan2(-eps*M[i][j], M[i][i])
+	} else {
+		theta[2] = math.Atan2(eps*M[j][i], M[j][j])
+	}
+	return theta
+}
 
-	case OrderZYX:
-		taitBryanAngles.R = math.Asin(-clamp(r.zx, -1, 1))
-		if math.Abs(r.zx) < lim1 {
-			taitBryanAngles.Q = math.Atan2(r.zy, r.zz)
-			taitBryanAngles.S = math.Atan2(r.yx, r.xx)
-		} else {
-			taitBryanAngles.Q = 0
-			taitBryanAngles.S = math.Atan2(-r.xy, r.yy)
+// Quaternion returns the attitude quaternion of the Euler angles. It is the
+// inverse of RotationMatrixFromQuat followed by RotationMatrix.Euler.
+func (e EulerAngles) Quaternion() quat.Number {
+	if e.Order <= orderUndefined || e.Order >= orderLen {
+		panic("undefined or unimplemented rotation order")
+	}
+	axes := rotationOrderAxes[e.Order]
+	var angles [3]float64
+	if e.Order.ProperEuler() {
+		angles = [3]float64{e.Q, e.R, e.S}
+	} else {
+		byAxis := [3]float64{e.Q, e.R, e.S}
+		for i, axis := range axes {
+			angles[i] = byAxis[axis]
 		}
-
-	case OrderYZX:
-		taitBryanAngles.S = math.Asin(clamp(r.yx, -1, 1))
-		if math.Abs(r.yx) < lim1 {
-			taitBryanAngles.Q = math.Atan2(-r.yz, r.yy)
-			taitBryanAngles.R = math.Atan2(-r.zx, r.xx)
-		} else {
-			taitBryanAngles.Q = 0
-			taitBryanAngles.R = math.Atan2(r.xz, r.zz)
+	}
+	q := quatIdentity
+	for i := range axes {
+		s, c := math.Sincos(angles[i] / 2)
+		rot := quat.Number{Real: c}
+		switch axes[i] {
+		case 0:
+			rot.Imag = s
+		case 1:
+			rot.Jmag = s
+		case 2:
+			rot.Kmag = s
 		}
-
-	case OrderXZY:
-		taitBryanAngles.S = math.Asin(-clamp(r.xy, -1, 1))
-		if math.Abs(r.xy) < lim1 {
-			taitBryanAngles.Q = math.Atan2(r.zy, r.yy)
-			taitBryanAngles.R = math.Atan2(r.xz, r.xx)
+		if e.Order.Intrinsic() {
+			q = quat.Mul(q, rot)
 		} else {
-			taitBryanAngles.Q = math.Atan2(-r.yz, r.zz)
-			taitBryanAngles.R = 0
+			q = quat.Mul(rot, q)
 		}
-
-	case orderUndefined:
-		fallthrough
-	default:
-		panic("undefined or unimplemented rotation order")
 	}
-
-	// Invert result since three.js has different rotation convention
-	taitBryanAngles.Q *= -1
-	taitBryanAngles.R *= -1
-	taitBryanAngles.S *= -1
-	return taitBryanAngles
+	return q
 }
 
 // clamp returns v if contained in [min,max].
@@ -0,0 +1,130 @@
+package ahrs
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/gonum/num/quat"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestRotationMatrixFromQuat(t *testing.T) {
+	const tol = 1e-12
+	q := NormalizeQuaternion(quat.Number{Real: 0.9, Imag: 0.3, Jmag: -0.2, Kmag: 0.1})
+	rot := RotationMatrixFromQuat(q)
+	for _, v := range []r3.Vec{{X: 1}, {Y: 1}, {Z: 1}} {
+		// The rotation matrix rotates earth frame vectors to the sensor frame.
+		got, want := rot.MulVec(v), rotateVecInv(q, v)
+		if d := r3.Norm(r3.Sub(got, want)); !(d <= tol) {
+			t.Errorf("rotating %v: expected %v, got %v", v, want, got)
+		}
+	}
+	// Columns of a rotation matrix are unit vectors.
+	if d := math.Abs(rot.xx*rot.xx + rot.yx*rot.yx + rot.zx*rot.zx - 1); !(d <= tol) {
+		t.Errorf("first column not unit length: %+v", rot)
+	}
+}
+
+func TestEulerRoundTrip(t *testing.T) {
+	const tol = 1e-9
+	rng := rand.New(rand.NewSource(1))
+	for order := orderUndefined + 1; order < orderLen; order++ {
+		for i := 0; i < 100; i++ {
+			want := eulerPositional(order,
+				(2*rng.Float64()-1)*math.Pi,
+				(2*rng.Float64()-1)*(math.Pi/2-1e-3), // Away from gimbal lock.
+				(2*rng.Float64()-1)*math.Pi,
+			)
+			q := want.Quaternion()
+			rot := RotationMatrixFromQuat(q)
+			got := rot.Euler(order)
+			if math.Abs(got.Q-want.Q) > tol || math.Abs(got.R-want.R) > tol || math.Abs(got.S-want.S) > tol {
+				t.Fatalf("%v: expected %+v, got %+v", order, want, got)
+			}
+		}
+	}
+}
+
+func TestEulerGimbalLock(t *testing.T) {
+	const tol = 1e-9
+	for order := orderUndefined + 1; order < orderLen; order++ {
+		// Middle angle of proper Euler orders is shifted to 0 and π.
+		for _, middle := range []float64{-math.Pi / 2, math.Pi / 2} {
+			q := eulerPositional(order, 0.3, middle, -0.4).Quaternion()
+			rot := RotationMatrixFromQuat(q)
+			got := rot.Euler(order)
+			if angle := quatAngle(q, got.Quaternion()); angle > tol {
+				t.Errorf("%v: gimbal lock angles %+v do not reproduce attitude (%g rad)", order, got, angle)
+			}
+		}
+	}
+}
+
+func TestTaitBryanAerospace(t *testing.T) {
+	const (
+		roll, pitch, yaw = 0.1, -0.2, 0.3
+		tol              = 1e-12
+	)
+	axis := func(a float64, x, y, z float64) quat.Number {
+		s, c := math.Sincos(a / 2)
+		return quat.Number{Real: c, Imag: s * x, Jmag: s * y, Kmag: s * z}
+	}
+	// Yaw, then pitch, then roll about the body axes.
+	q := quat.Mul(quat.Mul(axis(yaw, 0, 0, 1), axis(pitch, 0, 1, 0)), axis(roll, 1, 0, 0))
+	rot := RotationMatrixFromQuat(q)
+	got := rot.TaitBryan(OrderXYZ)
+	if math.Abs(got.Q-roll) > tol || math.Abs(got.R-pitch) > tol || math.Abs(got.S-yaw) > tol {
+		t.Errorf("expected roll %g pitch %g yaw %g, got %+v", roll, pitch, yaw, got)
+	}
+	if got := rot.TaitBryan(OrderIntrinsicZYX); math.Abs(got.Q-roll) > tol || math.Abs(got.R-pitch) > tol || math.Abs(got.S-yaw) > tol {
+		t.Errorf("intrinsic ZYX differs from extrinsic XYZ: %+v", got)
+	}
+}
+
+func TestRotationOrderComposition(t *testing.T) {
+	const tol = 1e-12
+	axis := func(i int, a float64) quat.Number {
+		s, c := math.Sincos(a / 2)
+		q := quat.Number{Real: c}
+		switch i {
+		case 0:
+			q.Imag = s
+		case 1:
+			q.Jmag = s
+		case 2:
+			q.Kmag = s
+		}
+		return q
+	}
+	angles := [3]float64{0.3, -0.5, 1.1}
+	for order := OrderXYZ; order < orderLen; order++ {
+		axes := rotationOrderAxes[order]
+		first, second, third := axis(axes[0], angles[0]), axis(axes[1], angles[1]), axis(axes[2], angles[2])
+		// Intrinsic rotations compose left to right, extrinsic right to left.
+		want := quat.Mul(quat.Mul(third, second), first)
+		if order.Intrinsic() {
+			want = quat.Mul(quat.Mul(first, second), third)
+		}
+		e := eulerPositional(order, angles[0], angles[1], angles[2])
+		if order.ProperEuler() {
+			e.R = angles[1]
+		}
+		if got := e.Quaternion(); quat.Abs(quat.Sub(got, want)) > tol {
+			t.Errorf("%v: expected %v, got %v", order, want, got)
+		}
+	}
+}
+
+// eulerPositional returns Euler angles of order from the first, second and third
+// rotation angles. The second angle is in [-π/2, π/2] and shifted to [0, π] for proper Euler orders.
+func eulerPositional(order RotationOrder, first, second, third float64) EulerAngles {
+	if order.ProperEuler() {
+		return EulerAngles{Q: first, R: second + math.Pi/2, S: third, Order: order}
+	}
+	var byAxis [3]float64
+	for i, angle := range [3]float64{first, second, third} {
+		byAxis[rotationOrderAxes[order][i]] = angle
+	}
+	return EulerAngles{Q: byAxis[0], R: byAxis[1], S: byAxis[2], Order: order}
+}
@@ -0,0 +1,116 @@
+package ahrs
+
+import (
+	"errors"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// BiasPolynomial models per axis sensor bias as a polynomial of temperature.
+type BiasPolynomial struct {
+	// Reference is the temperature in degrees Celsius about which the
+	// polynomial is expanded.
+	Reference float64 `json:"reference"`
+	// Coefficients holds per axis bias polynomial coefficients in ascending
+	// order of power of (temperature - Reference). Bias is in raw sensor units.
+	Coefficients [][3]float64 `json:"coefficients"`
+}
+
+// Bias returns the bias at temperature in degrees Celsius.
+func (p BiasPolynomial) Bias(celsius float64) r3.Vec {
+	t := celsius - p.Reference
+	var bias [3]float64
+	// Horner's method.
+	for i := len(p.Coefficients) - 1; i >= 0; i-- {
+		for axis := range bias {
+			bias[axis] = bias[axis]*t + p.Coefficients[i][axis]
+		}
+	}
+	return r3.Vec{X: bias[0], Y: bias[1], Z: bias[2]}
+}
+
+// Apply returns the reading v with the bias at temperature removed.
+func (p BiasPolynomial) Apply(v r3.Vec, celsius float64) r3.Vec {
+	return r3.Sub(v, p.Bias(celsius))
+}
+
+// FitBiasPolynomial fits a polynomial of the given degree to recorded
+// (temperature, bias) pairs by least squares. Temperatures are in degrees Celsius.
+func FitBiasPolynomial(temperatures []float64, biases []r3.Vec, degree int) (BiasPolynomial, error) {
+	n := len(temperatures)
+	switch {
+	case n != len(biases):
+		return BiasPolynomial{}, errors.New("temperature and bias length mismatch")
+	case degree < 0:
+		return BiasPolynomial{}, errors.New("negative polynomial degree")
+	case n < degree+1:
+		return BiasPolynomial{}, errors.New("not enough samples to fit bias polynomial")
+	}
+	var p BiasPolynomial
+	for _, t := range temperatures {
+		p.Reference += t
+	}
+	p.Reference /= float64(n)
+
+	V := mat.NewDense(n, degree+1, nil)
+	B := mat.NewDense(n, 3, nil)
+	for i, t := range temperatures {
+		pow := 1.0
+		for j := 0; j <= degree; j++ {
+			V.Set(i, j, pow)
+			pow *= t - p.Reference
+		}
+		B.SetRow(i, []float64{biases[i].X, biases[i].Y, biases[i].Z})
+	}
+	var coef mat.Dense
+	if err := coef.Solve(V, B); err != nil {
+		return BiasPolynomial{}, err
+	}
+	p.Coefficients = make([][3]float64, degree+1)
+	for j := range p.Coefficients {
+		p.Coefficients[j] = [3]float64{coef.At(j, 0), coef.At(j, 1), coef.At(j, 2)}
+	}
+	return p, nil
+}
+
+// ThermalCompensation holds temperature dependent bias models of
+// the gyroscope and accelerometer. A nil model disables compensation.
+type ThermalCompensation struct {
+	Gyro  *BiasPolynomial `json:"gyro,omitempty"`
+	Accel *BiasPolynomial `json:"accel,omitempty"`
+}
+
+// NewThermalCompensatedIMU returns an IMU which removes the temperature
+// dependent bias from the readings of imu using its reported temperature.
+func NewThermalCompensatedIMU(imu IMUTemperature, comp ThermalCompensation) IMUTemperature {
+	if imu == nil {
+		panic("nil IMU in NewThermalCompensatedIMU")
+	}
+	return thermalCompensatedIMU{IMUTemperature: imu, comp: comp}
+}
+
+type thermalCompensatedIMU struct {
+	IMUTemperature
+	comp ThermalCompensation
+}
+
+func (c thermalCompensatedIMU) celsius() float64 {
+	return 1e-3 * float64(c.IMUTemperature.Temperature())
+}
+
+func (c thermalCompensatedIMU) Acceleration() (ax, ay, az int32) {
+	ax, ay, az = c.IMUTemperature.Acceleration()
+	if c.comp.Accel == nil {
+		return ax, ay, az
+	}
+	return roundVec(c.comp.Accel.Apply(scaledVecFromInt(1, ax, ay, az), c.celsius()))
+}
+
+func (c thermalCompensatedIMU) AngularVelocity() (gx, gy, gz int32) {
+	gx, gy, gz = c.IMUTemperature.AngularVelocity()
+	if c.comp.Gyro == nil {
+		return gx, gy, gz
+	}
+	return roundVec(c.comp.Gyro.Apply(scaledVecFromInt(1, gx, gy, gz), c.celsius()))
+}
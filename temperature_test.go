@@ -0,0 +1,54 @@
+package ahrs
+
+import (
+	"testing"
+
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+type testThermalIMU struct {
+	testIMU
+	milliCelsius int32
+}
+
+func (t *testThermalIMU) Temperature() int32 { return t.milliCelsius }
+
+func TestThermalCompensation(t *testing.T) {
+	// Quadratic gyroscope bias in micro radians per second.
+	bias := func(celsius float64) r3.Vec {
+		return r3.Vec{
+			X: 100 + 20*celsius + 0.5*celsius*celsius,
+			Y: -300 + 5*celsius,
+			Z: 50 - 0.2*celsius*celsius,
+		}
+	}
+	var temps []float64
+	var biases []r3.Vec
+	for c := -20.0; c <= 80; c += 5 {
+		temps = append(temps, c)
+		biases = append(biases, bias(c))
+	}
+	poly, err := FitBiasPolynomial(temps, biases, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []float64{-15, 0, 23.5, 70} {
+		if d := r3.Norm(r3.Sub(poly.Bias(c), bias(c))); d > 1e-6 {
+			t.Errorf("bias at %g°C: expected %v, got %v", c, bias(c), poly.Bias(c))
+		}
+	}
+
+	imu := &testThermalIMU{milliCelsius: 40_000}
+	imu.gyro[0], imu.gyro[1], imu.gyro[2] = roundVec(bias(40))
+	imu.accel = [3]int32{0, 0, 1e6}
+	comp := NewThermalCompensatedIMU(imu, ThermalCompensation{Gyro: &poly})
+	if gx, gy, gz := comp.AngularVelocity(); gx != 0 || gy != 0 || gz != 0 {
+		t.Errorf("expected compensated gyroscope zero, got %d %d %d", gx, gy, gz)
+	}
+	if ax, ay, az := comp.Acceleration(); ax != 0 || ay != 0 || az != 1e6 {
+		t.Errorf("uncompensated accelerometer modified: %d %d %d", ax, ay, az)
+	}
+	if _, err := FitBiasPolynomial(temps[:2], biases[:2], 2); err == nil {
+		t.Error("expected error fitting with too few samples")
+	}
+}
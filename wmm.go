@@ -0,0 +1,228 @@
+package ahrs
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// WGS84 ellipsoid and geomagnetic reference radius in kilometers.
+const (
+	wgs84SemiMajorAxis  = 6378.137
+	wgs84Flattening     = 1 / 298.257223563
+	geomagneticRadius   = 6371.2
+	wgs84Eccentricity2  = wgs84Flattening * (2 - wgs84Flattening)
+	magneticModelDegree = 12
+	// magneticModelLifetime is the number of years after the epoch a model is valid for.
+	magneticModelLifetime = 5
+)
+
+// wmm2020 holds the World Magnetic Model 2020 coefficients published by
+// NOAA NCEI and the British Geological Survey.
+//
+//go:embed wmm2020.cof
+var wmm2020 []byte
+
+// embeddedMagneticModels holds the embedded model coefficients ordered by epoch,
+// oldest first. Newer releases are added at the end.
+var embeddedMagneticModels = [][]byte{wmm2020}
+
+// MagneticModel is a spherical harmonic model of the main geomagnetic field
+// such as the World Magnetic Model.
+type MagneticModel struct {
+	// Name is the model name, i.e. "WMM-2020".
+	Name string
+	// Epoch is the decimal year the coefficients are referenced to. The model is
+	// valid for the 5 years following the epoch.
+	Epoch float64
+	// Gauss coefficients and their secular variation indexed by [degree][order] in nT and nT/year.
+	g, h, gDot, hDot [magneticModelDegree + 1][magneticModelDegree + 1]float64
+}
+
+// MagneticField is the geomagnetic field at a location.
+type MagneticField struct {
+	// North, East and Down are the field components in nT.
+	North, East, Down float64
+	// Horizontal is the horizontal intensity in nT.
+	Horizontal float64
+	// Intensity is the total intensity in nT.
+	Intensity float64
+	// Declination is the angle in radians from true north to the horizontal field,
+	// positive towards east.
+	Declination float64
+	// Inclination is the angle in radians from the horizontal plane to the field,
+	// positive downwards.
+	Inclination float64
+}
+
+// WMM2020 returns the embedded World Magnetic Model 2020, valid from 2020.0 to 2025.0.
+// Fields for later dates require a newer release loaded with ParseMagneticModel.
+func WMM2020() *MagneticModel {
+	m, err := ParseMagneticModel(bytes.NewReader(wmm2020))
+	if err != nil {
+		panic(err) // embedded coefficients are known to be valid.
+	}
+	return m
+}
+
+// MagneticModelAt returns the most recent embedded magnetic model valid at
+// decimal year (i.e. 2022.5). It returns an error if no embedded model covers year,
+// in which case a newer release may be loaded with ParseMagneticModel.
+func MagneticModelAt(year float64) (*MagneticModel, error) {
+	for i := len(embeddedMagneticModels) - 1; i >= 0; i-- {
+		m, err := ParseMagneticModel(bytes.NewReader(embeddedMagneticModels[i]))
+		if err != nil {
+			panic(err) // embedded coefficients are known to be valid.
+		}
+		if dt := year - m.Epoch; dt >= 0 && dt <= magneticModelLifetime {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("no embedded magnetic model valid at year %g", year)
+}
+
+// ParseMagneticModel parses coefficients in the WMM.COF format distributed
+// by NOAA NCEI so that newer releases of the World Magnetic Model may be used.
+func ParseMagneticModel(r io.Reader) (*MagneticModel, error) {
+	var m MagneticModel
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return nil, errors.New("missing magnetic model header")
+	}
+	header := strings.Fields(scanner.Text())
+	if len(header) < 2 {
+		return nil, errors.New("invalid magnetic model header")
+	}
+	epoch, err := strconv.ParseFloat(header[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("magnetic model epoch: %w", err)
+	}
+	m.Epoch = epoch
+	m.Name = header[1]
+	for line := 2; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 1 && strings.HasPrefix(fields[0], "9999") {
+			break // End of coefficients.
+		}
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("line %d: expected 6 fields, got %d", line, len(fields))
+		}
+		var v [6]float64
+		for i, field := range fields {
+			v[i], err = strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		n, mo := int(v[0]), int(v[1])
+		if n < 1 || n > magneticModelDegree || mo < 0 || mo > n {
+			return nil, fmt.Errorf("line %d: invalid degree %d and order %d", line, n, mo)
+		}
+		m.g[n][mo], m.h[n][mo], m.gDot[n][mo], m.hDot[n][mo] = v[2], v[3], v[4], v[5]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Field returns the magnetic field at geodetic latitude and longitude in radians,
+// altitude in meters above the WGS84 ellipsoid and decimal year (i.e. 2022.5).
+// It returns an error if year is outside the validity window of the model
+// since the secular variation is not fit for extrapolation.
+func (m *MagneticModel) Field(latitude, longitude, altitude, year float64) (MagneticField, error) {
+	const N = magneticModelDegree
+	dt := year - m.Epoch
+	if !(dt >= 0 && dt <= magneticModelLifetime) {
+		return MagneticField{}, fmt.Errorf("year %g outside %s validity from %g to %g", year, m.Name, m.Epoch, m.Epoch+magneticModelLifetime)
+	}
+	h := altitude / 1000
+
+	// Geodetic to geocentric spherical coordinates.
+	sinLat, cosLat := math.Sincos(latitude)
+	rc := wgs84SemiMajorAxis / math.Sqrt(1-wgs84Eccentricity2*sinLat*sinLat)
+	p := (rc + h) * cosLat
+	z := (rc*(1-wgs84Eccentricity2) + h) * sinLat
+	r := math.Hypot(p, z)
+	latc := math.Asin(z / r)
+	// Colatitude cosine and sine.
+	cosT, sinT := math.Sincos(latc)
+
+	// Schmidt semi-normalized associated Legendre functions and
+	// their derivatives with respect to colatitude.
+	var P, dP [N + 1][N + 1]float64
+	P[0][0] = 1
+	for n := 1; n <= N; n++ {
+		if n == 1 {
+			P[1][1] = sinT
+			dP[1][1] = cosT
+		} else {
+			k := math.Sqrt(float64(2*n-1) / float64(2*n))
+			P[n][n] = k * sinT * P[n-1][n-1]
+			dP[n][n] = k * (cosT*P[n-1][n-1] + sinT*dP[n-1][n-1])
+		}
+		for mo := 0; mo < n; mo++ {
+			a := float64(2*n - 1)
+			b := math.Sqrt(float64((n-1)*(n-1) - mo*mo))
+			c := math.Sqrt(float64(n*n - mo*mo))
+			P[n][mo] = a * cosT * P[n-1][mo]
+			dP[n][mo] = a * (cosT*dP[n-1][mo] - sinT*P[n-1][mo])
+			if n >= 2 {
+				P[n][mo] -= b * P[n-2][mo]
+				dP[n][mo] -= b * dP[n-2][mo]
+			}
+			P[n][mo] /= c
+			dP[n][mo] /= c
+		}
+	}
+
+	// Field in geocentric spherical coordinates.
+	var xs, ys, zs float64
+	ratio := geomagneticRadius / r
+	scale := ratio * ratio
+	for n := 1; n <= N; n++ {
+		scale *= ratio
+		for mo := 0; mo <= n; mo++ {
+			g := m.g[n][mo] + dt*m.gDot[n][mo]
+			hc := m.h[n][mo] + dt*m.hDot[n][mo]
+			sinM, cosM := math.Sincos(float64(mo) * longitude)
+			gh := g*cosM + hc*sinM
+			xs += scale * gh * dP[n][mo]
+			ys += scale * float64(mo) * (g*sinM - hc*cosM) * P[n][mo]
+			zs -= scale * float64(n+1) * gh * P[n][mo]
+		}
+	}
+	// Avoid division by zero at the geographic poles.
+	ys /= math.Max(math.Abs(sinT), 1e-12)
+
+	// Rotate to geodetic frame.
+	sinD, cosD := math.Sincos(latc - latitude)
+	var f MagneticField
+	f.North = xs*cosD - zs*sinD
+	f.East = ys
+	f.Down = xs*sinD + zs*cosD
+	f.Horizontal = math.Hypot(f.North, f.East)
+	f.Intensity = math.Hypot(f.Horizontal, f.Down)
+	f.Declination = math.Atan2(f.East, f.North)
+	f.Inclination = math.Atan2(f.Down, f.Horizontal)
+	return f, nil
+}
+
+// Vector returns the field vector in nT in the earth frame of convention c.
+func (f MagneticField) Vector(c Convention) r3.Vec {
+	return c.fromNWU(r3.Vec{X: f.North, Y: -f.East, Z: -f.Down})
+}
+
+// Window returns the field intensity range in nT accepted as undisturbed
+// for a relative tolerance, i.e. 0.1 for ±10%.
+func (f MagneticField) Window(tolerance float64) (min, max float64) {
+	return f.Intensity * (1 - tolerance), f.Intensity * (1 + tolerance)
+}
@@ -0,0 +1,108 @@
+package ahrs
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWMM2020(t *testing.T) {
+	fp, err := os.Open("testdata/wmm2020_test_values.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	const deg = math.Pi / 180
+	model := WMM2020()
+	scanner := bufio.NewScanner(fp)
+	tested := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		var v []float64
+		for _, field := range strings.Fields(line) {
+			f, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v = append(v, f)
+		}
+		year, alt, lat, lon := v[0], v[1]*1000, v[2]*deg, v[3]*deg
+		got, err := model.Field(lat, lon, alt, year)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Test values are rounded to 0.1 nT and 0.01 degrees.
+		for _, c := range []struct {
+			name      string
+			got, want float64
+			tol       float64
+		}{
+			{"declination", got.Declination / deg, v[4], 0.01},
+			{"inclination", got.Inclination / deg, v[5], 0.01},
+			{"H", got.Horizontal, v[6], 0.1},
+			{"X", got.North, v[7], 0.1},
+			{"Y", got.East, v[8], 0.1},
+			{"Z", got.Down, v[9], 0.1},
+			{"F", got.Intensity, v[10], 0.1},
+		} {
+			if math.Abs(c.got-c.want) > c.tol {
+				t.Errorf("%g %gkm %g° %g°: %s expected %g, got %g", v[0], v[1], v[2], v[3], c.name, c.want, c.got)
+			}
+		}
+		tested++
+	}
+	if tested == 0 {
+		t.Fatal("no test values")
+	}
+}
+
+func TestMagneticModelValidity(t *testing.T) {
+	model := WMM2020()
+	for _, test := range []struct {
+		year  float64
+		valid bool
+	}{
+		{2019.99, false},
+		{2020, true},
+		{2022.5, true},
+		{2025, true},
+		{2025.01, false},
+		{math.NaN(), false},
+	} {
+		_, err := model.Field(0.5, 0.5, 0, test.year)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("year %g: expected valid %t, got error %v", test.year, test.valid, err)
+		}
+	}
+}
+
+func TestMagneticModelAt(t *testing.T) {
+	for _, test := range []struct {
+		year float64
+		name string
+	}{
+		{2019, ""},
+		{2020, "WMM-2020"},
+		{2022.5, "WMM-2020"},
+		{math.NaN(), ""},
+	} {
+		m, err := MagneticModelAt(test.year)
+		if test.name == "" {
+			if err == nil {
+				t.Errorf("year %g: expected error, got %s", test.year, m.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("year %g: %v", test.year, err)
+		} else if m.Name != test.name {
+			t.Errorf("year %g: expected %s, got %s", test.year, test.name, m.Name)
+		}
+	}
+}
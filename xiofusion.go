@@ -15,30 +15,64 @@ const (
 
 var quatIdentity = quat.Number{Real: 1}
 
+// XioOption configures an XioAHRS or XioAHRS32 on creation.
+type XioOption func(*xioConfig)
+
+type xioConfig struct {
+	convention Convention
+}
+
+func newXioConfig(opts []XioOption) (cfg xioConfig) {
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithConvention sets the earth frame convention of the attitude and all
+// earth referenced outputs. The default is ConventionNWU. A sensor whose axes
+// are aligned with the axes of the earth frame has identity attitude.
+func WithConvention(c Convention) XioOption {
+	if c >= conventionLen {
+		panic("invalid convention")
+	}
+	return func(cfg *xioConfig) { cfg.convention = c }
+}
+
 // NewXioAHRS instances a AHRS system with a IMU+heading sensor.
 // Subsequent calls to Update read from IMU.
-func NewXioAHRS(gain float64, imuWithMagnetometer IMUHeading) *XioAHRS {
-	f := NewXioARS(gain, imuWithMagnetometer)
+func NewXioAHRS(gain float64, imuWithMagnetometer IMUHeading, opts ...XioOption) *XioAHRS {
+	f := NewXioARS(gain, imuWithMagnetometer, opts...)
 	f.ahrs = imuWithMagnetometer
 	return f
 }
 
 // NewFusionAHRS instances a AHRS system with only IMU sensor readings.
 // Calls to Update read from IMU.
-func NewXioARS(gain float64, imu IMU) *XioAHRS {
+func NewXioARS(gain float64, imu IMU, opts ...XioOption) *XioAHRS {
 	if imu == nil {
 		panic("nil IMU in NewFusionAHRS")
 	}
+	f := NewXio(gain, opts...)
+	f.ars = imu
+	return f
+}
+
+// NewXio instances a AHRS system not bound to a sensor.
+// It is updated by calls to UpdateSample.
+func NewXio(gain float64, opts ...XioOption) *XioAHRS {
+	cfg := newXioConfig(opts)
 	f := &XioAHRS{
+		convention: cfg.convention,
 		gain:       gain,
 		maxMFS:     1e200,
-		attitude:   quatIdentity,
-		rampedGain: initialGain,
-		ars:        imu,
+		initGain:   initialGain,
+		initPeriod: initializationPeriod,
 	}
 	if !(gain > 0) {
 		f.gain = initialGain
 	}
+	f.Reset()
 	return f
 }
 
@@ -52,101 +86,353 @@ type XioAHRS struct {
 	rampedGain     float64
 	ahrs           IMUHeading
 	ars            IMU
+	// Initialisation gain ramp. rampedGain decreases from initGain to gain over initPeriod seconds.
+	initGain, initPeriod float64
+	initialising         bool
+	// Acceleration rejection threshold as squared half sine of error angle.
+	// Zero disables acceleration rejection.
+	accelRejection float64
+	// Acceleration recovery trigger state in seconds.
+	accelRecoveryPeriod, accelRecoveryTrigger, accelRecoveryTimeout float64
+	accelIgnored                                                    bool
+	// Magnetic rejection threshold as squared half sine of error angle.
+	// Zero disables magnetic rejection.
+	magRejection float64
+	// Magnetic recovery trigger state in seconds.
+	magRecoveryPeriod, magRecoveryTrigger float64
+	magIgnored                            bool
+	// Half feedback errors of the last update before rejection.
+	halfAccelFeedback, halfMagFeedback r3.Vec
+	clock                              sampleClock
+	// Earth frame convention of the attitude.
+	convention Convention
+}
+
+// XioStates holds the internal states of a XioAHRS after the last update.
+// Modelled after FusionAhrsInternalStates and FusionAhrsFlags.
+type XioStates struct {
+	// AccelerationError is the angle in radians between the measured and
+	// estimated direction of gravity. Errors above 90° are reported as 90°.
+	AccelerationError float64
+	// AccelerometerIgnored is true if the accelerometer was rejected or unavailable.
+	AccelerometerIgnored bool
+	// AccelerationRecoveryTrigger is the acceleration recovery trigger in seconds.
+	// When it exceeds the recovery period the accelerometer is forced back into the feedback.
+	AccelerationRecoveryTrigger float64
+	// AccelerationRecovery is true while the accelerometer is forced back into the feedback.
+	AccelerationRecovery bool
+	// MagneticError is the angle in radians between the measured and estimated
+	// direction of magnetic west. Errors above 90° are reported as 90°.
+	MagneticError float64
+	// MagnetometerIgnored is true if the magnetometer was rejected or unavailable.
+	MagnetometerIgnored bool
+	// MagneticRecoveryTrigger is the magnetic recovery trigger in seconds.
+	// When it exceeds the recovery period heading is reset to the magnetic heading.
+	MagneticRecoveryTrigger float64
+	// Initialising is true during the initialisation gain ramp.
+	Initialising bool
+	// Gain is the effective gain used in the last update.
+	Gain float64
+}
+
+// InternalStates returns the internal states of the estimator after the last
+// update. Useful for diagnosing rejection and convergence problems.
+func (f *XioAHRS) InternalStates() XioStates {
+	return XioStates{
+		AccelerationError:           feedbackAngle(f.halfAccelFeedback),
+		AccelerometerIgnored:        f.accelIgnored,
+		AccelerationRecoveryTrigger: f.accelRecoveryTrigger,
+		AccelerationRecovery:        f.accelRecoveryTrigger > f.accelRecoveryTimeout,
+		MagneticError:               feedbackAngle(f.halfMagFeedback),
+		MagnetometerIgnored:         f.magIgnored,
+		MagneticRecoveryTrigger:     f.magRecoveryTrigger,
+		Initialising:                f.initialising,
+		Gain:                        f.rampedGain,
+	}
+}
+
+// feedbackAngle returns the error angle of a half feedback error.
+func feedbackAngle(halfFeedback r3.Vec) float64 {
+	return math.Asin(math.Min(2*r3.Norm(halfFeedback), 1))
+}
+
+// SetInitialisation sets the gain the estimator starts with after creation or
+// Reset and the period in seconds over which it is ramped down to the
+// estimator gain. A high initial gain lets the estimator converge quickly from an
+// arbitrary orientation. A period of zero disables the initialisation phase.
+func (f *XioAHRS) SetInitialisation(gain, period float64) {
+	f.initGain = gain
+	f.initPeriod = period
+	if f.initialising {
+		f.startInitialisation()
+	}
+}
+
+// Initialising returns true while the estimator gain is being ramped down from
+// the initial gain after creation or Reset.
+func (f *XioAHRS) Initialising() bool { return f.initialising }
+
+// Reset resets the attitude to identity, clears rejection state and
+// restarts the initialisation phase. Settings are preserved.
+func (f *XioAHRS) Reset() {
+	f.attitude = quatIdentity
+	f.acceleration = r3.Vec{}
+	f.accelRecoveryTrigger = 0
+	f.accelRecoveryTimeout = f.accelRecoveryPeriod
+	f.accelIgnored = false
+	f.magRecoveryTrigger = 0
+	f.magIgnored = false
+	f.halfAccelFeedback = r3.Vec{}
+	f.halfMagFeedback = r3.Vec{}
+	f.clock = sampleClock{}
+	f.startInitialisation()
 }
 
-func (f *XioAHRS) getVectors() (accel, rot, magnet r3.Vec) {
-	ax, ay, az := f.ars.Acceleration()
-	gx, gy, gz := f.ars.AngularVelocity()
+func (f *XioAHRS) startInitialisation() {
+	f.initialising = f.initPeriod > 0 && f.initGain > f.gain
+	f.rampedGain = f.gain
+	if f.initialising {
+		f.rampedGain = f.initGain
+	}
+}
 
-	accel = scaledVecFromInt(1e-6, ax, ay, az)
-	rot = scaledVecFromInt(1e-6, gx, gy, gz)
-	if f.ahrs == nil {
-		magnet = r3.Vec{X: 1} // prevent singularities
-		return
+func (f *XioAHRS) SetGain(gain float64) {
+	f.gain = gain
+	if !f.initialising {
+		f.rampedGain = gain
 	}
-	mx, my, mz := f.ahrs.North()
-	magnet = scaledVecFromInt(1, mx, my, mz)
-	return accel, rot, magnet
 }
 
-func (f *XioAHRS) SetGain(gain float64) { f.gain = gain }
+// SetAccelerationRejection enables accelerometer rejection during linear
+// acceleration. The accelerometer is ignored when the angle between the measured
+// and estimated direction of gravity exceeds threshold (radians). If the accelerometer
+// is ignored for longer than recoveryPeriod (seconds) it is forced back into the
+// feedback until the error subsides. A threshold of zero disables rejection.
+func (f *XioAHRS) SetAccelerationRejection(threshold, recoveryPeriod float64) {
+	f.accelRejection = math.Pow(0.5*math.Sin(threshold), 2)
+	f.accelRecoveryPeriod = recoveryPeriod
+	f.accelRecoveryTrigger = 0
+	f.accelRecoveryTimeout = recoveryPeriod
+}
+
+// AccelerometerIgnored returns true if the accelerometer was ignored
+// during the last update.
+func (f *XioAHRS) AccelerometerIgnored() bool { return f.accelIgnored }
+
+// SetMagneticRejection enables magnetometer rejection during magnetic disturbances.
+// The magnetometer is ignored when the heading error between the measured and
+// estimated magnetic field exceeds threshold (radians). If the magnetometer is ignored
+// for longer than recoveryPeriod (seconds) the heading is reset to the magnetometer
+// heading. A threshold of zero disables rejection.
+func (f *XioAHRS) SetMagneticRejection(threshold, recoveryPeriod float64) {
+	f.magRejection = math.Pow(0.5*math.Sin(threshold), 2)
+	f.magRecoveryPeriod = recoveryPeriod
+	f.magRecoveryTrigger = 0
+}
+
+// MagnetometerIgnored returns true if the magnetometer was ignored
+// during the last update. This is always true for estimators without magnetometer.
+func (f *XioAHRS) MagnetometerIgnored() bool { return f.magIgnored }
 
+// SetMagneticField sets the range of magnetic field intensity in nanoteslas
+// outside of which magnetometer readings are ignored.
 func (f *XioAHRS) SetMagneticField(min, max float64) {
 	f.minMFS = min * min
 	f.maxMFS = max * max
 }
 
-// Update updates the internal quaternion
+// SetExpectedMagneticField sets the accepted magnetic field intensity range to
+// within a relative tolerance of the local field, i.e. obtained from the World Magnetic Model.
+func (f *XioAHRS) SetExpectedMagneticField(field MagneticField, tolerance float64) {
+	f.SetMagneticField(field.Window(tolerance))
+}
+
+// Update reads the IMU and updates the internal quaternion.
+// It panics if the estimator was not created with a IMU.
+// The estimate is left unchanged if samplePeriod is not positive.
 func (f *XioAHRS) Update(samplePeriod float64) {
-	q := f.attitude
-	var accel, gyro, magnet r3.Vec = f.getVectors()
+	if f.ars == nil {
+		panic("Update called on XioAHRS without IMU. Use UpdateSample")
+	}
+	f.UpdateSample(readSample(f.ars, f.ahrs, samplePeriod))
+}
 
+// update updates the internal quaternion with accelerometer readings in
+// gravities, gyroscope readings in radians per second and magnetometer readings
+// in nanoteslas. Magnetometer readings are ignored if hasMag is false.
+func (f *XioAHRS) update(accel, gyro, magnet r3.Vec, hasMag bool, samplePeriod float64) {
+	q := f.attitude
+	// Ramp down gain during initialisation
+	if f.initialising {
+		f.rampedGain -= (f.initGain - f.gain) * samplePeriod / f.initPeriod
+		if f.rampedGain <= f.gain {
+			f.rampedGain = f.gain
+			f.initialising = false
+		}
+	}
 	// Half feedback error calculation
 	var hfe, halfWest, aux, gd2 r3.Vec
 	// If measurement is invalid, end calculation
 	mfs := 0.0
+	f.accelIgnored = true
+	f.magIgnored = true
+	f.halfAccelFeedback = r3.Vec{}
+	f.halfMagFeedback = r3.Vec{}
 	if accel.X == 0 && accel.Y == 0 && accel.Z == 0 {
 		goto ENDCALC
 	}
 
 	// Calculate direction of gravity assumed by quaternion
-	gd2 = r3.Vec{ // half gravity
-		X: q.Imag*q.Kmag - q.Real*q.Jmag,
-		Y: q.Real*q.Imag + q.Jmag*q.Kmag,
-		Z: q.Real*q.Real - .5 + q.Kmag*q.Kmag,
-	} // equal to 3rd column of rotation matrix representation scaled by 0.5
-	hfe = r3.Cross(r3.Unit(accel), gd2)
+	gd2 = halfGravity(f.convention, q)
+	hfe = feedback(r3.Unit(accel), gd2)
+	f.halfAccelFeedback = hfe
+	f.accelIgnored = f.rejectAccel(hfe, samplePeriod)
+	if f.accelIgnored {
+		hfe = r3.Vec{}
+	}
 
 	// Abandon magnetometer feedback calculation if magnetometer measurement invalid
 	mfs = r3.Norm2(magnet)
-	if f.ahrs == nil || mfs < f.minMFS || mfs > f.maxMFS {
+	if !hasMag || mfs < f.minMFS || mfs > f.maxMFS {
 		goto ENDCALC
 	}
 
 	// Compute direction of 'magnetic west' assumed by quaternion
-	halfWest = r3.Vec{
-		X: q.Imag*q.Jmag + q.Real*q.Kmag,
-		Y: q.Real*q.Real - 0.5 + q.Jmag*q.Jmag,
-		Z: q.Jmag*q.Kmag - q.Real*q.Imag,
-	} // equal to 2nd column of rotation matrix representation scaled by 0.5
+	halfWest = halfMagnetic(f.convention, q)
 
 	// calculate magnetometer feedback error
-	aux = r3.Cross(accel, magnet)
-	hfe = r3.Add(hfe, r3.Cross(r3.Unit(aux), halfWest))
-ENDCALC:
-
-	if f.gain == 0 {
-		f.rampedGain = 0
+	aux = feedback(r3.Unit(r3.Cross(gd2, magnet)), halfWest)
+	f.halfMagFeedback = aux
+	f.magIgnored = f.rejectMag(aux, accel, magnet, samplePeriod)
+	if !f.magIgnored {
+		hfe = r3.Add(hfe, aux)
 	}
+ENDCALC:
 
-	feedbackGain := f.gain
-	if f.rampedGain > f.gain {
-		f.rampedGain -= (initialGain - f.gain) * samplePeriod / initializationPeriod
-	}
 	halfGyro := r3.Scale(0.5, gyro)
 
 	// apply feedback to gyro
-	halfGyro = r3.Add(halfGyro, r3.Scale(feedbackGain, hfe))
+	halfGyro = r3.Add(halfGyro, r3.Scale(f.rampedGain, hfe))
 	f.attitude = quat.Add(f.attitude, mulQuatVec(f.attitude, r3.Scale(samplePeriod, halfGyro)))
 
 	// Normalize quaternion
 	f.attitude = NormalizeQuaternion(f.attitude)
 
-	// Calculate linear acceleration
-	gravity := r3.Vec{
-		X: 2.0 * (q.Imag*q.Kmag - q.Real*q.Jmag),
-		Y: 2.0 * (q.Real*q.Imag + q.Jmag*q.Kmag),
-		Z: 2.0 * (q.Real*q.Real - 0.5 + q.Kmag*q.Kmag),
+	// Estimators built without a magnetometer have no heading reference,
+	// discard change in Yaw. A sample without magnetometer reading
+	// on a magnetometer equipped estimator leaves Yaw to the gyroscope.
+	if !hasMag && f.ars != nil && f.ahrs == nil {
+		f.SetHeading(0)
+	}
+
+	// Calculate linear acceleration with updated quaternion
+	f.acceleration = r3.Sub(accel, r3.Scale(2, halfGravity(f.convention, f.attitude)))
+}
+
+// rejectAccel returns true if the accelerometer half feedback error
+// should be ignored and advances the acceleration recovery trigger.
+func (f *XioAHRS) rejectAccel(hfe r3.Vec, samplePeriod float64) (ignore bool) {
+	if f.accelRejection == 0 || f.initialising {
+		return false
+	}
+	if r3.Norm2(hfe) <= f.accelRejection {
+		f.accelRecoveryTrigger -= 9 * samplePeriod
+	} else {
+		ignore = true
+		f.accelRecoveryTrigger += samplePeriod
+	}
+	// Don't ignore accelerometer during acceleration recovery.
+	if f.accelRecoveryTrigger > f.accelRecoveryTimeout {
+		f.accelRecoveryTimeout = 0
+		ignore = false
+	} else {
+		f.accelRecoveryTimeout = f.accelRecoveryPeriod
+	}
+	f.accelRecoveryTrigger = clamp(f.accelRecoveryTrigger, 0, f.accelRecoveryPeriod)
+	return ignore
+}
+
+// rejectMag returns true if the magnetometer half feedback error should be
+// ignored and advances the magnetic recovery trigger. When the trigger exceeds
+// the recovery period the heading is reset to the measured magnetic heading.
+func (f *XioAHRS) rejectMag(hfe, accel, magnet r3.Vec, samplePeriod float64) (ignore bool) {
+	if f.magRejection == 0 || f.initialising {
+		return false
+	}
+	if r3.Norm2(hfe) <= f.magRejection {
+		f.magRecoveryTrigger = math.Max(0, f.magRecoveryTrigger-9*samplePeriod)
+		return false
+	}
+	f.magRecoveryTrigger += samplePeriod
+	if f.magRecoveryTrigger > f.magRecoveryPeriod {
+		// Disturbance persisted, assume the field changed and trust it.
+		f.SetHeading(CompassHeading(f.convention, accel, magnet))
+		f.magRecoveryTrigger = 0
+	}
+	return true
+}
+
+// halfGravity returns half the direction of gravity measured by the accelerometer
+// (up) in the sensor frame assumed by quaternion q of convention c.
+func halfGravity(c Convention, q quat.Number) r3.Vec {
+	if c == ConventionNED {
+		return r3.Vec{
+			X: q.Real*q.Jmag - q.Imag*q.Kmag,
+			Y: -(q.Jmag*q.Kmag + q.Real*q.Imag),
+			Z: 0.5 - q.Real*q.Real - q.Kmag*q.Kmag,
+		} // equal to negated 3rd column of rotation matrix representation scaled by 0.5
 	}
-	f.acceleration = r3.Sub(accel, gravity)
+	return r3.Vec{
+		X: q.Imag*q.Kmag - q.Real*q.Jmag,
+		Y: q.Real*q.Imag + q.Jmag*q.Kmag,
+		Z: q.Real*q.Real - .5 + q.Kmag*q.Kmag,
+	} // equal to 3rd column of rotation matrix representation scaled by 0.5
+}
 
-	// no magnetometer correction discards change in Yaw.
-	if f.ahrs == nil {
-		f.setYaw(0)
+// halfMagnetic returns half the direction of magnetic west in the
+// sensor frame assumed by quaternion q of convention c.
+func halfMagnetic(c Convention, q quat.Number) r3.Vec {
+	switch c {
+	case ConventionENU:
+		return r3.Vec{
+			X: 0.5 - q.Real*q.Real - q.Imag*q.Imag,
+			Y: q.Real*q.Kmag - q.Imag*q.Jmag,
+			Z: -(q.Imag*q.Kmag + q.Real*q.Jmag),
+		} // equal to negated 1st column of rotation matrix representation scaled by 0.5
+	case ConventionNED:
+		return r3.Vec{
+			X: -(q.Imag*q.Jmag + q.Real*q.Kmag),
+			Y: 0.5 - q.Real*q.Real - q.Jmag*q.Jmag,
+			Z: q.Real*q.Imag - q.Jmag*q.Kmag,
+		} // equal to negated 2nd column of rotation matrix representation scaled by 0.5
 	}
+	return r3.Vec{
+		X: q.Imag*q.Jmag + q.Real*q.Kmag,
+		Y: q.Real*q.Real - 0.5 + q.Jmag*q.Jmag,
+		Z: q.Jmag*q.Kmag - q.Real*q.Imag,
+	} // equal to 2nd column of rotation matrix representation scaled by 0.5
+}
+
+// feedback returns the half feedback error between a measured sensor direction
+// and the half reference direction. The error is normalized if greater than 90 degrees.
+func feedback(sensor, halfReference r3.Vec) r3.Vec {
+	if r3.Dot(sensor, halfReference) < 0 {
+		return r3.Unit(r3.Cross(sensor, halfReference))
+	}
+	return r3.Cross(sensor, halfReference)
+}
+
+// SetQuaternion sets the attitude quaternion, i.e. to initialise the
+// estimator from a known pose. q is normalized.
+func (f *XioAHRS) SetQuaternion(q quat.Number) {
+	f.attitude = NormalizeQuaternion(q)
 }
 
-func (f *XioAHRS) setYaw(yaw float64) {
+// SetHeading sets the heading (yaw) of the attitude in radians while preserving
+// roll and pitch, i.e. after a GPS heading fix. Estimators created with NewXioARS
+// hold heading at zero so SetHeading has no lasting effect on them. Estimators with a
+// magnetometer converge back to the magnetic heading at the rate set by the gain.
+func (f *XioAHRS) SetHeading(yaw float64) {
 	q := f.GetQuaternion()
 	// Calculate inverse yaw
 	iyaw := math.Atan2(q.Imag*q.Jmag+q.Real*q.Kmag, q.Real*q.Real-.5+q.Imag*q.Imag) // Euler angle of conjugate
@@ -166,6 +452,11 @@ func scaledVecFromInt(scale float64, x, y, z int32) (result r3.Vec) {
 	return result
 }
 
+// roundVec rounds the components of v to the nearest integer.
+func roundVec(v r3.Vec) (x, y, z int32) {
+	return int32(math.Round(v.X)), int32(math.Round(v.Y)), int32(math.Round(v.Z))
+}
+
 func (f *XioAHRS) GetQuaternion() quat.Number {
 	return f.attitude
 }
@@ -174,6 +465,14 @@ func (f *XioAHRS) GetQuaternion() quat.Number {
 // 	return quatToEuler(f.attitude)
 // }
 
+// GetLinearAcceleration returns the acceleration in the sensor frame
+// with gravity removed, in gravities.
 func (f *XioAHRS) GetLinearAcceleration() r3.Vec {
 	return f.acceleration
 }
+
+// GetEarthAcceleration returns the acceleration in the earth frame of
+// convention c with gravity removed, in gravities.
+func (f *XioAHRS) GetEarthAcceleration(c Convention) r3.Vec {
+	return c.fromNWU(f.convention.toNWU(rotateVec(f.attitude, f.acceleration)))
+}
@@ -1,34 +1,45 @@
 package ahrs
 
 import (
+	"math"
+
 	"github.com/go-gl/mathgl/mgl32"
 )
 
 // NewXioAHRS instances a AHRS system with a IMU+heading sensor.
 // Subsequent calls to Update read from IMU.
-func NewXioAHRS32(gain float64, imuWithMagnetometer IMUHeading) *XioAHRS32 {
-	f := NewXioARS32(gain, imuWithMagnetometer)
+func NewXioAHRS32(gain float64, imuWithMagnetometer IMUHeading, opts ...XioOption) *XioAHRS32 {
+	f := NewXioARS32(gain, imuWithMagnetometer, opts...)
 	f.ahrs = imuWithMagnetometer
 	return f
 }
 
 // NewFusionAHRS instances a AHRS system with only IMU sensor readings.
 // Calls to Update read from IMU.
-func NewXioARS32(gain float64, imu IMU) *XioAHRS32 {
-	println("warning: running untested code XioARS32")
+func NewXioARS32(gain float64, imu IMU, opts ...XioOption) *XioAHRS32 {
 	if imu == nil {
 		panic("nil IMU in NewFusionAHRS")
 	}
+	f := NewXio32(gain, opts...)
+	f.ars = imu
+	return f
+}
+
+// NewXio32 instances a AHRS system not bound to a sensor.
+// It is updated by calls to UpdateSample.
+func NewXio32(gain float64, opts ...XioOption) *XioAHRS32 {
+	cfg := newXioConfig(opts)
 	f := &XioAHRS32{
+		convention: cfg.convention,
 		gain:       float32(gain),
 		maxMFS:     1e20,
-		attitude:   mgl32.QuatIdent(),
-		rampedGain: float32(initialGain),
-		ars:        imu,
+		initGain:   initialGain,
+		initPeriod: initializationPeriod,
 	}
 	if !(gain > 0) {
 		f.gain = initialGain
 	}
+	f.Reset()
 	return f
 }
 
@@ -43,113 +54,367 @@ type XioAHRS32 struct {
 	rampedGain   float32
 	ahrs         IMUHeading
 	ars          IMU
+	// Initialisation gain ramp. rampedGain decreases from initGain to gain over initPeriod seconds.
+	initGain, initPeriod float32
+	initialising         bool
+	// Acceleration rejection threshold as squared half sine of error angle.
+	// Zero disables acceleration rejection.
+	accelRejection float32
+	// Acceleration recovery trigger state in seconds.
+	accelRecoveryPeriod, accelRecoveryTrigger, accelRecoveryTimeout float32
+	accelIgnored                                                    bool
+	// Magnetic rejection threshold as squared half sine of error angle.
+	// Zero disables magnetic rejection.
+	magRejection float32
+	// Magnetic recovery trigger state in seconds.
+	magRecoveryPeriod, magRecoveryTrigger float32
+	magIgnored                            bool
+	// Half feedback errors of the last update before rejection.
+	halfAccelFeedback, halfMagFeedback mgl32.Vec3
+	clock                              sampleClock
+	// Earth frame convention of the attitude.
+	convention Convention
+}
+
+// XioStates32 holds the internal states of a XioAHRS32 after the last update.
+// See XioStates.
+type XioStates32 struct {
+	AccelerationError           float32
+	AccelerometerIgnored        bool
+	AccelerationRecoveryTrigger float32
+	AccelerationRecovery        bool
+	MagneticError               float32
+	MagnetometerIgnored         bool
+	MagneticRecoveryTrigger     float32
+	Initialising                bool
+	Gain                        float32
 }
 
-func (f *XioAHRS32) getVectors() (accel, rot, magnet mgl32.Vec3) {
-	ax, ay, az := f.ars.Acceleration()
-	gx, gy, gz := f.ars.AngularVelocity()
+// InternalStates returns the internal states of the estimator after the last update.
+func (f *XioAHRS32) InternalStates() XioStates32 {
+	return XioStates32{
+		AccelerationError:           feedbackAngle32(f.halfAccelFeedback),
+		AccelerometerIgnored:        f.accelIgnored,
+		AccelerationRecoveryTrigger: f.accelRecoveryTrigger,
+		AccelerationRecovery:        f.accelRecoveryTrigger > f.accelRecoveryTimeout,
+		MagneticError:               feedbackAngle32(f.halfMagFeedback),
+		MagnetometerIgnored:         f.magIgnored,
+		MagneticRecoveryTrigger:     f.magRecoveryTrigger,
+		Initialising:                f.initialising,
+		Gain:                        f.rampedGain,
+	}
+}
+
+// feedbackAngle32 returns the error angle of a half feedback error.
+func feedbackAngle32(halfFeedback mgl32.Vec3) float32 {
+	sine := min_32(2*halfFeedback.Len(), 1)
+	// asin as atan2 of sine and cosine.
+	return atan2_32(sine, float32(math.Sqrt(float64(1-sine*sine))))
+}
+
+// SetInitialisation sets the gain the estimator starts with after creation or
+// Reset and the period in seconds over which it is ramped down to the
+// estimator gain. A high initial gain lets the estimator converge quickly from an
+// arbitrary orientation. A period of zero disables the initialisation phase.
+func (f *XioAHRS32) SetInitialisation(gain, period float32) {
+	f.initGain = gain
+	f.initPeriod = period
+	if f.initialising {
+		f.startInitialisation()
+	}
+}
+
+// Initialising returns true while the estimator gain is being ramped down from
+// the initial gain after creation or Reset.
+func (f *XioAHRS32) Initialising() bool { return f.initialising }
+
+// Reset resets the attitude to identity, clears rejection state and
+// restarts the initialisation phase. Settings are preserved.
+func (f *XioAHRS32) Reset() {
+	f.attitude = mgl32.QuatIdent()
+	f.acceleration = mgl32.Vec3{}
+	f.accelRecoveryTrigger = 0
+	f.accelRecoveryTimeout = f.accelRecoveryPeriod
+	f.accelIgnored = false
+	f.magRecoveryTrigger = 0
+	f.magIgnored = false
+	f.halfAccelFeedback = mgl32.Vec3{}
+	f.halfMagFeedback = mgl32.Vec3{}
+	f.clock = sampleClock{}
+	f.startInitialisation()
+}
+
+func (f *XioAHRS32) startInitialisation() {
+	f.initialising = f.initPeriod > 0 && f.initGain > f.gain
+	f.rampedGain = f.gain
+	if f.initialising {
+		f.rampedGain = f.initGain
+	}
+}
 
-	accel = scaledVec32FromInt(1e-6, ax, ay, az)
-	rot = scaledVec32FromInt(1e-6, gx, gy, gz)
-	if f.ahrs == nil {
-		magnet = mgl32.Vec3{0: 1} // prevent singularities
-		return
+func (f *XioAHRS32) SetGain(gain float32) {
+	f.gain = gain
+	if !f.initialising {
+		f.rampedGain = gain
 	}
-	mx, my, mz := f.ahrs.North()
-	magnet = scaledVec32FromInt(1, mx, my, mz)
-	return accel, rot, magnet
 }
 
-func (f *XioAHRS32) SetGain(gain float32) { f.gain = gain }
+// SetAccelerationRejection enables accelerometer rejection during linear
+// acceleration. The accelerometer is ignored when the angle between the measured
+// and estimated direction of gravity exceeds threshold (radians). If the accelerometer
+// is ignored for longer than recoveryPeriod (seconds) it is forced back into the
+// feedback until the error subsides. A threshold of zero disables rejection.
+func (f *XioAHRS32) SetAccelerationRejection(threshold, recoveryPeriod float32) {
+	halfSin := 0.5 * sin_32(threshold)
+	f.accelRejection = halfSin * halfSin
+	f.accelRecoveryPeriod = recoveryPeriod
+	f.accelRecoveryTrigger = 0
+	f.accelRecoveryTimeout = recoveryPeriod
+}
+
+// AccelerometerIgnored returns true if the accelerometer was ignored
+// during the last update.
+func (f *XioAHRS32) AccelerometerIgnored() bool { return f.accelIgnored }
+
+// SetMagneticRejection enables magnetometer rejection during magnetic disturbances.
+// The magnetometer is ignored when the heading error between the measured and
+// estimated magnetic field exceeds threshold (radians). If the magnetometer is ignored
+// for longer than recoveryPeriod (seconds) the heading is reset to the magnetometer
+// heading. A threshold of zero disables rejection.
+func (f *XioAHRS32) SetMagneticRejection(threshold, recoveryPeriod float32) {
+	halfSin := 0.5 * sin_32(threshold)
+	f.magRejection = halfSin * halfSin
+	f.magRecoveryPeriod = recoveryPeriod
+	f.magRecoveryTrigger = 0
+}
+
+// MagnetometerIgnored returns true if the magnetometer was ignored
+// during the last update. This is always true for estimators without magnetometer.
+func (f *XioAHRS32) MagnetometerIgnored() bool { return f.magIgnored }
 
+// SetMagneticField sets the range of magnetic field intensity in nanoteslas
+// outside of which magnetometer readings are ignored.
 func (f *XioAHRS32) SetMagneticField(min, max float32) {
 	f.minMFS = min * min
 	f.maxMFS = max * max
 }
 
-// Update updates the internal quaternion
+// SetExpectedMagneticField sets the accepted magnetic field intensity range to
+// within a relative tolerance of the local field, i.e. obtained from the World Magnetic Model.
+func (f *XioAHRS32) SetExpectedMagneticField(field MagneticField, tolerance float64) {
+	min, max := field.Window(tolerance)
+	f.SetMagneticField(float32(min), float32(max))
+}
+
+// Update reads the IMU and updates the internal quaternion.
+// It panics if the estimator was not created with a IMU.
+// The estimate is left unchanged if samplePeriod is not positive.
 func (f *XioAHRS32) Update(samplePeriod float32) {
-	q := f.attitude
-	var accel, gyro, magnet mgl32.Vec3 = f.getVectors()
+	if f.ars == nil {
+		panic("Update called on XioAHRS32 without IMU. Use UpdateSample")
+	}
+	f.UpdateSample(readSample(f.ars, f.ahrs, float64(samplePeriod)))
+}
 
+// update updates the internal quaternion with accelerometer readings in
+// gravities, gyroscope readings in radians per second and magnetometer readings
+// in nanoteslas. Magnetometer readings are ignored if hasMag is false.
+func (f *XioAHRS32) update(accel, gyro, magnet mgl32.Vec3, hasMag bool, samplePeriod float32) {
+	q := f.attitude
+	// Ramp down gain during initialisation
+	if f.initialising {
+		f.rampedGain -= (f.initGain - f.gain) * samplePeriod / f.initPeriod
+		if f.rampedGain <= f.gain {
+			f.rampedGain = f.gain
+			f.initialising = false
+		}
+	}
 	// Half feedback error calculation
 	var hfe, halfWest, aux, gd2 mgl32.Vec3
 	// If measurement is invalid, end calculation
 	var mfs float32
+	f.accelIgnored = true
+	f.magIgnored = true
+	f.halfAccelFeedback = mgl32.Vec3{}
+	f.halfMagFeedback = mgl32.Vec3{}
 	if accel[0] == 0 && accel[1] == 0 && accel[2] == 0 {
 		goto ENDCALC
 	}
 
 	// Calculate direction of gravity assumed by quaternion
-	gd2 = mgl32.Vec3{ // half gravity
-		q.X()*q.Z() - q.W*q.Y(),
-		q.W*q.X() + q.Y()*q.Z(),
-		q.W*q.W - .5 + q.Z()*q.Z(),
-	} // equal to 3rd column of rotation matrix representation scaled by 0.5
-	aux = accel.Normalize()
-	hfe = aux.Cross(gd2)
+	gd2 = halfGravity32(f.convention, q)
+	hfe = feedback32(accel.Normalize(), gd2)
+	f.halfAccelFeedback = hfe
+	f.accelIgnored = f.rejectAccel(hfe, samplePeriod)
+	if f.accelIgnored {
+		hfe = mgl32.Vec3{}
+	}
 
 	// Abandon magnetometer feedback calculation if magnetometer measurement invalid
 	mfs = magnet.Dot(magnet) // Norm2 of magnet
-	if f.ahrs == nil || mfs < f.minMFS || mfs > f.maxMFS {
+	if !hasMag || mfs < f.minMFS || mfs > f.maxMFS {
 		goto ENDCALC
 	}
 
 	// Compute direction of 'magnetic west' assumed by quaternion
-	halfWest = mgl32.Vec3{
-		q.X()*q.Y() + q.W*q.Z(),
-		q.W*q.W - 0.5 + q.Y()*q.Y(),
-		q.Y()*q.Z() - q.W*q.X(),
-	} // equal to 2nd column of rotation matrix representation scaled by 0.5
+	halfWest = halfMagnetic32(f.convention, q)
 
 	// calculate magnetometer feedback error
-	aux = accel.Cross(magnet)
-	hfe = hfe.Add(aux.Normalize().Cross(halfWest))
+	aux = feedback32(gd2.Cross(magnet).Normalize(), halfWest)
+	f.halfMagFeedback = aux
+	f.magIgnored = f.rejectMag(aux, accel, magnet, samplePeriod)
+	if !f.magIgnored {
+		hfe = hfe.Add(aux)
+	}
 
 ENDCALC:
 
-	if f.gain == 0 {
-		f.rampedGain = 0
-	}
-
-	feedbackGain := f.gain
-	if f.rampedGain > f.gain {
-		f.rampedGain -= (initialGain - f.gain) * samplePeriod / initializationPeriod
-	}
 	halfGyro := gyro.Mul(0.5)
 
 	// apply feedback to gyro
-	halfGyro = halfGyro.Add(hfe.Mul(feedbackGain))
+	halfGyro = halfGyro.Add(hfe.Mul(f.rampedGain))
 	f.attitude = f.attitude.Add(mulQuatVec32(f.attitude, halfGyro.Mul(samplePeriod)))
 
 	// Normalize quaternion
 	f.attitude = f.attitude.Normalize()
 
-	// Calculate linear acceleration
-	gravity := mgl32.Vec3{
-		2.0 * (q.X()*q.Z() - q.W*q.Y()),
-		2.0 * (q.W*q.X() + q.Y()*q.Z()),
-		2.0 * (q.W*q.W - 0.5 + q.Z()*q.Z()),
+	// Estimators built without a magnetometer have no heading reference,
+	// discard change in Yaw. A sample without magnetometer reading
+	// on a magnetometer equipped estimator leaves Yaw to the gyroscope.
+	if !hasMag && f.ars != nil && f.ahrs == nil {
+		f.SetHeading(0)
 	}
-	f.acceleration = accel.Sub(gravity)
 
-	// no magnetometer correction discards change in Yaw.
-	if f.ahrs == nil {
-		f.setYaw(0)
+	// Calculate linear acceleration with updated quaternion
+	f.acceleration = accel.Sub(halfGravity32(f.convention, f.attitude).Mul(2))
+}
+
+// rejectAccel returns true if the accelerometer half feedback error
+// should be ignored and advances the acceleration recovery trigger.
+func (f *XioAHRS32) rejectAccel(hfe mgl32.Vec3, samplePeriod float32) (ignore bool) {
+	if f.accelRejection == 0 || f.initialising {
+		return false
+	}
+	if hfe.Dot(hfe) <= f.accelRejection {
+		f.accelRecoveryTrigger -= 9 * samplePeriod
+	} else {
+		ignore = true
+		f.accelRecoveryTrigger += samplePeriod
+	}
+	// Don't ignore accelerometer during acceleration recovery.
+	if f.accelRecoveryTrigger > f.accelRecoveryTimeout {
+		f.accelRecoveryTimeout = 0
+		ignore = false
+	} else {
+		f.accelRecoveryTimeout = f.accelRecoveryPeriod
 	}
+	f.accelRecoveryTrigger = max_32(0, min_32(f.accelRecoveryPeriod, f.accelRecoveryTrigger))
+	return ignore
 }
 
-func (f *XioAHRS32) setYaw(yaw float32) {
+// rejectMag returns true if the magnetometer half feedback error should be
+// ignored and advances the magnetic recovery trigger. When the trigger exceeds
+// the recovery period the heading is reset to the measured magnetic heading.
+func (f *XioAHRS32) rejectMag(hfe, accel, magnet mgl32.Vec3, samplePeriod float32) (ignore bool) {
+	if f.magRejection == 0 || f.initialising {
+		return false
+	}
+	if hfe.Dot(hfe) <= f.magRejection {
+		f.magRecoveryTrigger = max_32(0, f.magRecoveryTrigger-9*samplePeriod)
+		return false
+	}
+	f.magRecoveryTrigger += samplePeriod
+	if f.magRecoveryTrigger > f.magRecoveryPeriod {
+		// Disturbance persisted, assume the field changed and trust it.
+		f.SetHeading(compassHeading32(f.convention, accel, magnet))
+		f.magRecoveryTrigger = 0
+	}
+	return true
+}
+
+// compassHeading32 returns the tilt compensated magnetic heading in radians
+// in convention c.
+func compassHeading32(c Convention, accel, magnet mgl32.Vec3) float32 {
+	west := accel.Cross(magnet).Normalize()
+	north := west.Cross(accel).Normalize()
+	switch c {
+	case ConventionENU:
+		return atan2_32(north[0], -west[0])
+	case ConventionNED:
+		return atan2_32(-west[0], north[0])
+	}
+	return atan2_32(west[0], north[0])
+}
+
+// halfGravity32 is the 32 bit version of halfGravity.
+func halfGravity32(c Convention, q mgl32.Quat) mgl32.Vec3 {
+	if c == ConventionNED {
+		return mgl32.Vec3{
+			q.W*q.Y() - q.X()*q.Z(),
+			-(q.Y()*q.Z() + q.W*q.X()),
+			0.5 - q.W*q.W - q.Z()*q.Z(),
+		}
+	}
+	return mgl32.Vec3{
+		q.X()*q.Z() - q.W*q.Y(),
+		q.W*q.X() + q.Y()*q.Z(),
+		q.W*q.W - .5 + q.Z()*q.Z(),
+	}
+}
+
+// halfMagnetic32 is the 32 bit version of halfMagnetic.
+func halfMagnetic32(c Convention, q mgl32.Quat) mgl32.Vec3 {
+	switch c {
+	case ConventionENU:
+		return mgl32.Vec3{
+			0.5 - q.W*q.W - q.X()*q.X(),
+			q.W*q.Z() - q.X()*q.Y(),
+			-(q.X()*q.Z() + q.W*q.Y()),
+		}
+	case ConventionNED:
+		return mgl32.Vec3{
+			-(q.X()*q.Y() + q.W*q.Z()),
+			0.5 - q.W*q.W - q.Y()*q.Y(),
+			q.W*q.X() - q.Y()*q.Z(),
+		}
+	}
+	return mgl32.Vec3{
+		q.X()*q.Y() + q.W*q.Z(),
+		q.W*q.W - 0.5 + q.Y()*q.Y(),
+		q.Y()*q.Z() - q.W*q.X(),
+	}
+}
+
+// feedback32 returns the half feedback error between a measured sensor direction
+// and the half reference direction. The error is normalized if greater than 90 degrees.
+func feedback32(sensor, halfReference mgl32.Vec3) mgl32.Vec3 {
+	if sensor.Dot(halfReference) < 0 {
+		return sensor.Cross(halfReference).Normalize()
+	}
+	return sensor.Cross(halfReference)
+}
+
+// SetQuaternion sets the attitude quaternion, i.e. to initialise the
+// estimator from a known pose. q is normalized.
+func (f *XioAHRS32) SetQuaternion(q mgl32.Quat) {
+	f.attitude = q.Normalize()
+}
+
+// SetHeading sets the heading (yaw) of the attitude in radians while preserving
+// roll and pitch. See XioAHRS.SetHeading.
+func (f *XioAHRS32) SetHeading(yaw float32) {
 	q := f.GetQuaternion()
 	// Calculate inverse yaw
-	iyaw := atan2_32(q.X()*q.Y()+q.W*q.Z(), q.W*q.W-.5+q.X()*q.X()) // Euler angle of conjugate
+	iyaw := float32(math.Atan2(float64(q.X()*q.Y()+q.W*q.Z()), float64(q.W*q.W-.5+q.X()*q.X()))) // Euler angle of conjugate
 	//half inverse yaw minus offset?
 	hiymo := 0.5 * (iyaw - yaw)
+	// hiymo spans ±π, outside the range of the cos_32 approximation.
+	sin, cos := math.Sincos(float64(hiymo))
 	iyawQuat := mgl32.Quat{
-		W: cos_32(hiymo),
+		W: float32(cos),
 	}
-	iyawQuat.V[2] = -sin_32(hiymo)
-	f.attitude = iyawQuat.Mul(f.attitude)
+	iyawQuat.V[2] = -float32(sin)
+	f.attitude = iyawQuat.Mul(f.attitude).Normalize()
 }
 
 // func scaledVecFromInt(scale float64, x, y, z int32) (result r3.Vec) {
@@ -172,6 +437,14 @@ func (f *XioAHRS32) GetQuaternion() mgl32.Quat {
 // 	return quatToEuler(f.attitude)
 // }
 
+// GetLinearAcceleration returns the acceleration in the sensor frame
+// with gravity removed, in gravities.
 func (f *XioAHRS32) GetLinearAcceleration() mgl32.Vec3 {
 	return f.acceleration
 }
+
+// GetEarthAcceleration returns the acceleration in the earth frame of
+// convention c with gravity removed, in gravities.
+func (f *XioAHRS32) GetEarthAcceleration(c Convention) mgl32.Vec3 {
+	return c.fromNWU32(f.convention.toNWU32(f.attitude.Rotate(f.acceleration)))
+}
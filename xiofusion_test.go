@@ -0,0 +1,349 @@
+package ahrs
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/num/quat"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// testIMU is an IMUHeading with fixed readings.
+type testIMU struct {
+	accel, gyro, north [3]int32
+}
+
+func (t *testIMU) Acceleration() (ax, ay, az int32) {
+	return t.accel[0], t.accel[1], t.accel[2]
+}
+
+func (t *testIMU) AngularVelocity() (gx, gy, gz int32) {
+	return t.gyro[0], t.gyro[1], t.gyro[2]
+}
+
+func (t *testIMU) North() (mx, my, mz int32) {
+	return t.north[0], t.north[1], t.north[2]
+}
+
+func TestXioAccelerationRejection(t *testing.T) {
+	const (
+		dt             = 0.01
+		recoveryPeriod = 2.0
+	)
+	imu := &testIMU{accel: [3]int32{0, 0, 1e6}}
+	f := NewXioARS(0.5, imu)
+	f.SetAccelerationRejection(10*math.Pi/180, recoveryPeriod)
+	for i := 0; i < 500; i++ {
+		f.Update(dt)
+	}
+	if f.AccelerometerIgnored() {
+		t.Fatal("accelerometer ignored while still")
+	}
+	// Sustained lateral acceleration of 1g.
+	imu.accel = [3]int32{1e6, 0, 1e6}
+	for i := 0; i < int(recoveryPeriod/dt)-10; i++ {
+		f.Update(dt)
+		if !f.AccelerometerIgnored() {
+			t.Fatalf("accelerometer not ignored during linear acceleration at %gs", float64(i)*dt)
+		}
+	}
+	if q := f.GetQuaternion(); math.Abs(q.Real-1) > 1e-6 {
+		t.Errorf("attitude affected by ignored accelerometer: %v", q)
+	}
+	for i := 0; i < 20; i++ {
+		f.Update(dt)
+	}
+	if f.AccelerometerIgnored() {
+		t.Error("accelerometer not recovered after recovery period")
+	}
+}
+
+func TestXioMagneticRejection(t *testing.T) {
+	const (
+		dt             = 0.01
+		recoveryPeriod = 2.0
+	)
+	imu := &testIMU{accel: [3]int32{0, 0, 1e6}, north: [3]int32{20000, 0, -45000}}
+	f := NewXioAHRS(0.5, imu)
+	f.SetMagneticRejection(10*math.Pi/180, recoveryPeriod)
+	for i := 0; i < 500; i++ {
+		f.Update(dt)
+	}
+	if f.MagnetometerIgnored() {
+		t.Fatal("magnetometer ignored in undisturbed field")
+	}
+	// Disturbance rotates field 90 degrees.
+	imu.north = [3]int32{0, 20000, -45000}
+	for i := 0; i < int(recoveryPeriod/dt)-10; i++ {
+		f.Update(dt)
+		if !f.MagnetometerIgnored() {
+			t.Fatalf("magnetometer not ignored during disturbance at %gs", float64(i)*dt)
+		}
+	}
+	if yaw := quatYaw(f.GetQuaternion()); math.Abs(yaw) > 1e-6 {
+		t.Errorf("heading affected by ignored magnetometer: %g", yaw)
+	}
+	for i := 0; i < 20; i++ {
+		f.Update(dt)
+	}
+	if f.MagnetometerIgnored() {
+		t.Error("magnetometer not recovered after recovery period")
+	}
+	if yaw := quatYaw(f.GetQuaternion()); math.Abs(yaw+math.Pi/2) > 1e-3 {
+		t.Errorf("expected heading reset to %g, got %g", -math.Pi/2, yaw)
+	}
+}
+
+func quatYaw(q quat.Number) float64 {
+	return math.Atan2(2*(q.Real*q.Kmag+q.Imag*q.Jmag), 1-2*(q.Jmag*q.Jmag+q.Kmag*q.Kmag))
+}
+
+func TestXioInitialisationConvergence(t *testing.T) {
+	const (
+		dt   = 0.01
+		gain = 0.5
+		tol  = 1 * math.Pi / 180
+	)
+	// Arbitrary starting orientation far from the true level, north facing attitude.
+	start := NormalizeQuaternion(quat.Number{Real: 0.3, Imag: -0.7, Jmag: 0.5, Kmag: 0.4})
+	convergenceTime := func(f *XioAHRS) float64 {
+		f.attitude = start
+		for i := 0; i < 10_000; i++ {
+			f.Update(dt)
+			q := f.GetQuaternion()
+			// Angle between estimate and identity.
+			if 2*math.Acos(math.Min(1, math.Abs(q.Real))) < tol {
+				return float64(i+1) * dt
+			}
+		}
+		return math.Inf(1)
+	}
+	imu := &testIMU{accel: [3]int32{0, 0, 1e6}, north: [3]int32{20000, 0, -45000}}
+	f := NewXioAHRS(gain, imu)
+	if !f.Initialising() {
+		t.Fatal("new estimator not initialising")
+	}
+	withRamp := convergenceTime(f)
+	if withRamp > initializationPeriod {
+		t.Errorf("expected convergence during initialisation period %gs, took %gs", initializationPeriod, withRamp)
+	}
+	for f.Initialising() {
+		f.Update(dt)
+	}
+
+	f.Reset()
+	if !f.Initialising() {
+		t.Fatal("estimator not initialising after Reset")
+	}
+	if resetTime := convergenceTime(f); resetTime != withRamp {
+		t.Errorf("expected same convergence time after Reset, got %gs and %gs", withRamp, resetTime)
+	}
+
+	f.SetInitialisation(0, 0)
+	f.Reset()
+	if f.Initialising() {
+		t.Fatal("estimator initialising with initialisation disabled")
+	}
+	withoutRamp := convergenceTime(f)
+	if withoutRamp < 2*withRamp {
+		t.Errorf("expected initialisation to speed up convergence, got %gs with ramp and %gs without", withRamp, withoutRamp)
+	}
+	t.Logf("convergence time with initialisation %gs, without %gs", withRamp, withoutRamp)
+}
+
+func TestXioSetHeading(t *testing.T) {
+	const (
+		roll, pitch = 0.2, -0.1
+		tol         = 1e-9
+		tol32       = 1e-5
+	)
+	axis := func(a float64, x, y, z float64) quat.Number {
+		s, c := math.Sincos(a / 2)
+		return quat.Number{Real: c, Imag: s * x, Jmag: s * y, Kmag: s * z}
+	}
+	yaws := []float64{-math.Pi + 1e-3, -3, -2, -1, 0, 0.5, 1.2, 2, 3, math.Pi - 1e-3}
+	for _, current := range yaws {
+		start := quat.Mul(quat.Mul(axis(current, 0, 0, 1), axis(pitch, 0, 1, 0)), axis(roll, 1, 0, 0))
+		for _, heading := range yaws {
+			f := NewXio(0.5)
+			f.SetQuaternion(start)
+			f.SetHeading(heading)
+			q := f.GetQuaternion()
+			if n := quat.Abs(q); math.Abs(n-1) > tol {
+				t.Errorf("yaw %g to %g: expected unit quaternion, got norm %g", current, heading, n)
+			}
+			if got := quatYaw(q); math.Abs(got-heading) > tol {
+				t.Errorf("yaw %g to %g: got heading %g", current, heading, got)
+			}
+			// Roll and pitch are preserved if the up direction in the sensor frame is unchanged.
+			up, wantUp := rotateVecInv(q, r3.Vec{Z: 1}), rotateVecInv(start, r3.Vec{Z: 1})
+			if d := r3.Norm(r3.Sub(up, wantUp)); d > tol {
+				t.Errorf("yaw %g to %g: roll and pitch changed: up %v, expected %v", current, heading, up, wantUp)
+			}
+
+			f32 := NewXio32(0.5)
+			f32.SetAttitude(start)
+			f32.SetHeading(float32(heading))
+			q32 := f32.GetQuaternion()
+			if n := float64(q32.Len()); math.Abs(n-1) > tol32 {
+				t.Errorf("32 bit yaw %g to %g: expected unit quaternion, got norm %g", current, heading, n)
+			}
+			q = quat.Number{Real: float64(q32.W), Imag: float64(q32.V[0]), Jmag: float64(q32.V[1]), Kmag: float64(q32.V[2])}
+			if got := quatYaw(q); math.Abs(math.Remainder(got-heading, 2*math.Pi)) > tol32 {
+				t.Errorf("32 bit yaw %g to %g: got heading %g", current, heading, got)
+			}
+		}
+	}
+}
+
+func TestXioEarthAcceleration(t *testing.T) {
+	const tol = 1e-3
+	// Sensor yawed 90° (facing west) and rolled 30°, accelerating north at 0.5g.
+	attitude := quat.Mul(
+		quat.Number{Real: math.Cos(math.Pi / 4), Kmag: math.Sin(math.Pi / 4)},
+		quat.Number{Real: math.Cos(math.Pi / 12), Imag: math.Sin(math.Pi / 12)},
+	)
+	earth := r3.Vec{X: 0.5}
+	accel := rotateVecInv(attitude, r3.Add(earth, r3.Vec{Z: 1}))
+	expect := map[Convention]r3.Vec{
+		ConventionNWU: {X: 0.5},
+		ConventionENU: {Y: 0.5},
+		ConventionNED: {X: 0.5},
+	}
+	// Zero gain so that the linear acceleration does not disturb the attitude.
+	f := NewXio(0.5)
+	f.SetInitialisation(0, 0)
+	f.SetGain(0)
+	f32 := NewXio32(0.5)
+	f32.SetInitialisation(0, 0)
+	f32.SetGain(0)
+	for _, e := range []Estimator{f, f32} {
+		e.SetAttitude(attitude)
+		e.UpdateSample(Sample{
+			Accel:  r3.Scale(StandardGravity, accel),
+			Mag:    rotateVecInv(attitude, r3.Vec{X: 20e-6, Z: -45e-6}),
+			Period: 0.01,
+		})
+	}
+	for c, want := range expect {
+		got := f.GetEarthAcceleration(c)
+		if d := r3.Norm(r3.Sub(got, want)); d > tol {
+			t.Errorf("%v: expected %v, got %v", c, want, got)
+		}
+		got32 := f32.GetEarthAcceleration(c)
+		if d := r3.Norm(r3.Sub(r3.Vec{X: float64(got32[0]), Y: float64(got32[1]), Z: float64(got32[2])}, want)); d > 1e-6 {
+			t.Errorf("32 bit %v: expected %v, got %v", c, want, got32)
+		}
+	}
+}
+
+func TestXioConvention(t *testing.T) {
+	const dt = 0.01
+	// Level sensor with axes aligned with the earth frame axes in each convention.
+	readings := map[Convention]Sample{
+		ConventionNWU: {Accel: r3.Vec{Z: StandardGravity}, Mag: r3.Vec{X: 20e-6, Z: -45e-6}},
+		ConventionENU: {Accel: r3.Vec{Z: StandardGravity}, Mag: r3.Vec{Y: 20e-6, Z: -45e-6}},
+		ConventionNED: {Accel: r3.Vec{Z: -StandardGravity}, Mag: r3.Vec{X: 20e-6, Z: 45e-6}},
+	}
+	start := NormalizeQuaternion(quat.Number{Real: 0.9, Imag: 0.1, Jmag: -0.2, Kmag: 0.4})
+	for c, s := range readings {
+		f := NewXio(0.5, WithConvention(c))
+		f32 := NewXio32(0.5, WithConvention(c))
+		for _, e := range []Estimator{f, f32} {
+			e.SetAttitude(start)
+			s.Period = dt
+			for i := 0; i < 1000; i++ {
+				e.UpdateSample(s)
+			}
+			if angle := quatAngle(quatIdentity, e.Attitude()); angle > 1e-6 {
+				t.Errorf("%v %T: expected identity attitude, got %v", c, e, e.Attitude())
+			}
+		}
+		if heading := CompassHeading(c, r3.Scale(1/StandardGravity, s.Accel), s.Mag); math.Abs(heading) > 1e-9 {
+			t.Errorf("%v: expected zero compass heading, got %g", c, heading)
+		}
+	}
+}
+
+func TestXioInternalStates(t *testing.T) {
+	const (
+		dt             = 0.01
+		recoveryPeriod = 1.0
+	)
+	imu := &testIMU{accel: [3]int32{0, 0, 1e6}}
+	f := NewXioARS(0.5, imu)
+	f32 := NewXioARS32(0.5, imu)
+	f.SetAccelerationRejection(10*math.Pi/180, recoveryPeriod)
+	f32.SetAccelerationRejection(10*math.Pi/180, recoveryPeriod)
+	f.Update(dt)
+	f32.Update(dt)
+	if s := f.InternalStates(); !s.Initialising || s.Gain <= 0.5 || s.AccelerationError != 0 {
+		t.Errorf("unexpected states during initialisation: %+v", s)
+	}
+	for i := 0; i < int(initializationPeriod/dt); i++ {
+		f.Update(dt)
+		f32.Update(dt)
+	}
+	// Gravity measured 45° off the estimate.
+	imu.accel = [3]int32{1e6, 0, 1e6}
+	for i := 0; i < 50; i++ {
+		f.Update(dt)
+		f32.Update(dt)
+	}
+	s, s32 := f.InternalStates(), f32.InternalStates()
+	if s.Initialising || s.Gain != 0.5 {
+		t.Errorf("expected initialisation finished with gain 0.5, got %+v", s)
+	}
+	if math.Abs(s.AccelerationError-math.Pi/4) > 1e-6 || !s.AccelerometerIgnored || s.AccelerationRecovery {
+		t.Errorf("expected ignored 45° acceleration error, got %+v", s)
+	}
+	if math.Abs(s.AccelerationRecoveryTrigger-0.5) > 1e-6 {
+		t.Errorf("expected acceleration recovery trigger 0.5s, got %g", s.AccelerationRecoveryTrigger)
+	}
+	if math.Abs(float64(s32.AccelerationError)-math.Pi/4) > 1e-3 || !s32.AccelerometerIgnored {
+		t.Errorf("32 bit: expected ignored 45° acceleration error, got %+v", s32)
+	}
+	for i := 0; i < 60; i++ {
+		f.Update(dt)
+	}
+	if s := f.InternalStates(); !s.AccelerationRecovery || s.AccelerometerIgnored {
+		t.Errorf("expected acceleration recovery, got %+v", s)
+	}
+}
+
+func TestXioSlowMagnetometer(t *testing.T) {
+	const (
+		dt      = 0.01
+		yaw     = 1.0
+		magEach = 10 // Magnetometer sampled at a tenth of the IMU rate.
+		tol     = 1 * math.Pi / 180
+	)
+	attitude := quat.Number{Real: math.Cos(yaw / 2), Kmag: math.Sin(yaw / 2)}
+	accel := rotateVecInv(attitude, r3.Vec{Z: 1e6})
+	north := rotateVecInv(attitude, r3.Vec{X: 20000, Z: -40000})
+	// A sample without magnetometer reading does not reset heading.
+	f := NewXio(0.5)
+	f.SetQuaternion(attitude)
+	f.UpdateSample(Sample{Accel: r3.Vec{Z: StandardGravity}, Period: dt})
+	if got := quatYaw(f.Attitude()); math.Abs(got-yaw) > tol {
+		t.Errorf("sample without magnetometer reset heading to %g, expected %g", got, yaw)
+	}
+
+	imu := &testIMU{accel: [3]int32{int32(accel.X), int32(accel.Y), int32(accel.Z)}}
+	ahrs := NewXioAHRS(0.5, imu)
+	ahrs32 := NewXioAHRS32(0.5, imu)
+	for i := 0; i < 6000; i++ {
+		imu.north = [3]int32{}
+		if i%magEach == 0 {
+			imu.north = [3]int32{int32(north.X), int32(north.Y), int32(north.Z)}
+		}
+		ahrs.Update(dt)
+		ahrs32.Update(dt)
+	}
+	if got := quatYaw(ahrs.Attitude()); math.Abs(got-yaw) > tol {
+		t.Errorf("expected heading %g, got %g", yaw, got)
+	}
+	if got := quatYaw(ahrs32.Attitude()); math.Abs(got-yaw) > tol {
+		t.Errorf("32 bit: expected heading %g, got %g", yaw, got)
+	}
+}